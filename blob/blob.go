@@ -13,6 +13,8 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/moov-io/cryptfs"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
 
 	"gocloud.dev/blob"
 	_ "gocloud.dev/blob/azureblob"
@@ -22,6 +24,20 @@ import (
 	_ "gocloud.dev/blob/s3blob"
 )
 
+var (
+	uploadSize     metric.Int64Histogram
+	uploadFailures metric.Int64Counter
+)
+
+func init() {
+	meter := otel.Meter("github.com/sithumonline/demedia-nostr/blob")
+	uploadSize, _ = meter.Int64Histogram("blob.upload.size",
+		metric.WithDescription("Size of the files saved to the bucket"),
+		metric.WithUnit("By"))
+	uploadFailures, _ = meter.Int64Counter("blob.upload.failures",
+		metric.WithDescription("Files that failed to be saved to the bucket"))
+}
+
 type AuditTrail struct {
 	ID        string
 	BucketURI string
@@ -82,9 +98,14 @@ func (bs *BlobStorage) Close() error {
 	return bs.bucket.Close()
 }
 
-func (bs *BlobStorage) SaveFile(filepath string, data []byte) error {
+func (bs *BlobStorage) SaveFile(filepath string, data []byte) (err error) {
+	defer func() {
+		if err != nil {
+			uploadFailures.Add(context.Background(), 1)
+		}
+	}()
+
 	var encrypted []byte
-	var err error
 	if bs.cryptor != nil {
 		encrypted, err = bs.cryptor.Disfigure(data)
 	} else {
@@ -118,6 +139,7 @@ func (bs *BlobStorage) SaveFile(filepath string, data []byte) error {
 		return fmt.Errorf("copyErr=%v closeErr=%v", copyErr, closeErr)
 	}
 
+	uploadSize.Record(context.Background(), int64(len(data)))
 	return nil
 }
 
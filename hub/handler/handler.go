@@ -1,13 +1,16 @@
 package handler
 
 import (
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sithumonline/demedia-nostr/hub/outbox"
+	"github.com/sithumonline/demedia-nostr/relayer/ql"
 	"github.com/sithumonline/demedia-nostr/relayer/storage/postgresql"
 )
 
-func Start(port string, m map[string]postgresql.PeerInfo) {
+func Start(port string, db *postgresql.PostgresBackend, ob *outbox.Outbox, caps func() map[string]ql.Capabilities) {
 	r := gin.Default()
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Add("Access-Control-Allow-Origin", "*")
@@ -17,7 +20,11 @@ func Start(port string, m map[string]postgresql.PeerInfo) {
 	v1 := r.Group("/v1")
 	{
 		v1.GET("/data", func(c *gin.Context) {
-			c.JSON(http.StatusOK, gin.H{"data": m})
+			stats, err := ob.Stats()
+			if err != nil {
+				log.Printf("failed to get outbox stats: %v", err)
+			}
+			c.JSON(http.StatusOK, gin.H{"data": db.Peers().Snapshot(), "outbox": stats, "capabilities": caps()})
 		})
 	}
 
@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
@@ -12,10 +16,13 @@ import (
 	"github.com/nbd-wtf/go-nostr"
 	p2pHost "github.com/sithumonline/demedia-nostr/host"
 	"github.com/sithumonline/demedia-nostr/hub/handler"
+	"github.com/sithumonline/demedia-nostr/hub/outbox"
 	"github.com/sithumonline/demedia-nostr/hub/ping"
 	"github.com/sithumonline/demedia-nostr/ipfs"
 	"github.com/sithumonline/demedia-nostr/keys"
 	"github.com/sithumonline/demedia-nostr/relayer"
+	"github.com/sithumonline/demedia-nostr/relayer/policy"
+	"github.com/sithumonline/demedia-nostr/relayer/ql"
 	"github.com/sithumonline/demedia-nostr/relayer/storage/postgresql"
 	"github.com/sithumonline/demedia-nostr/trace"
 )
@@ -25,6 +32,18 @@ type Relay struct {
 
 	storage *postgresql.PostgresBackend
 
+	outbox *outbox.Outbox
+
+	// the client of the server, set once it is initialized
+	ql *ql.Client
+
+	// serves the pings of the peers once the relay is initialized
+	rpc *gorpc.Server
+
+	// what each peer supports, by address
+	capsMu sync.Mutex
+	caps   map[string]peerCapabilities
+
 	Hex string `envconfig:"HEX" default:"fad9c8855b740a0b7ed4c221dbad0f33a83a49cad6b3fe8d5817ac83d38b6a19"`
 
 	WebPort string `envconfig:"WEB_PORT" default:"3030"`
@@ -43,11 +62,53 @@ type Relay struct {
 
 	TraceExporter string `envconfig:"TRACE_EXPORTER" default:"jaeger"`
 
+	MetricExporter string `envconfig:"METRIC_EXPORTER" default:"prometheus"`
+
+	LogExporter string `envconfig:"LOG_EXPORTER" default:""`
+
+	// TraceSampleRatio is the share of the websocket connections traced, the
+	// spans of their messages follow.
+	TraceSampleRatio float64 `envconfig:"TRACE_SAMPLE_RATIO" default:"1"`
+
+	TraceParentBased bool `envconfig:"TRACE_PARENT_BASED" default:"true"`
+
 	InfuraProjectID string `envconfig:"INFURA_PROJECT_ID" default:""`
 
 	InfuraProjectSecret string `envconfig:"INFURA_PROJECT_SECRET" default:""`
 
 	ServiceName string `envconfig:"SERVICE_NAME" default:""`
+
+	PeerTTL time.Duration `envconfig:"PEER_TTL" default:"5s"`
+
+	Replicas int `envconfig:"REPLICATION_FACTOR" default:"1"`
+
+	Quorum int `envconfig:"WRITE_QUORUM" default:"1"`
+
+	// PublicURL is the websocket URL clients reach the hub at, which they
+	// sign in their NIP-42 AUTH events. Without it no AUTH would match.
+	PublicURL string `envconfig:"PUBLIC_URL" default:""`
+
+	// PolicyFile is a JSON file with the rules events must follow, see
+	// policy.Config. Only the size of events is limited without it.
+	PolicyFile string `envconfig:"POLICY_FILE" default:""`
+
+	policy *policy.Policy
+
+	// serves the Prometheus metrics at /metrics, nil with another
+	// MetricExporter
+	metrics http.Handler
+
+	// Limits are read from RATE_LIMIT_EVENTS_PER_IP and the like, see
+	// relayer.RateLimits.
+	Limits relayer.RateLimits `envconfig:"RATE_LIMIT"`
+}
+
+// how long the capabilities of a peer are trusted before asking it again
+const capabilitiesTTL = time.Minute
+
+type peerCapabilities struct {
+	ql.Capabilities
+	fetched time.Time
 }
 
 func (r *Relay) Name() string {
@@ -58,17 +119,118 @@ func (r *Relay) Name() string {
 	return r.ServiceName
 }
 
+// ServiceURL returns the URL NIP-42 AUTH events must be signed for.
+func (r *Relay) ServiceURL() string {
+	return r.PublicURL
+}
+
 func (r *Relay) Storage() relayer.Storage {
 	return r.storage
 }
 
-func (r *Relay) OnInitialized(*relayer.Server) {}
+func (r *Relay) ReplicationFactor() int {
+	return r.Replicas
+}
+
+func (r *Relay) WriteQuorum() int {
+	return r.Quorum
+}
+
+func (r *Relay) OnInitialized(s *relayer.Server) {
+	if r.metrics != nil {
+		s.Router().Path("/metrics").Handler(r.metrics)
+	}
+	if err := r.outbox.Init(r.storage.DB); err != nil {
+		log.Fatalf("failed to init outbox: %v", err)
+	}
+	r.ql = s.QlClient()
+	// the storage, the outbox and the client are ready by now
+	pingService := ping.NewPingService(r)
+	if err := r.rpc.Register(pingService); err != nil {
+		log.Fatalf("failed to register rpc server: %v", err)
+	}
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		for range ticker.C {
+			now := time.Now()
+			r.storage.Peers().Expire(now)
+			pingService.Expire(now)
+		}
+	}()
+	go handler.Start(fmt.Sprintf(":%s", r.WebPort), r.storage, r.outbox, r.Capabilities)
+}
+
+func (r *Relay) QueueEvent(evt nostr.Event) error {
+	return r.outbox.Queue(evt)
+}
+
+func (r *Relay) PeerOnline(address string, pubkeys []string) {
+	for _, pubkey := range pubkeys {
+		r.outbox.Deliver(pubkey, address)
+	}
+
+	r.capsMu.Lock()
+	c, ok := r.caps[address]
+	stale := !ok || time.Since(c.fetched) > capabilitiesTTL
+	if stale {
+		// keep the old ones meanwhile, and don't ask twice
+		c.fetched = time.Now()
+		r.caps[address] = c
+	}
+	r.capsMu.Unlock()
+	if stale {
+		go r.refreshCapabilities(address)
+	}
+}
+
+func (r *Relay) refreshCapabilities(address string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	caps, err := r.ql.GetCapabilities(ctx, address)
+	if err != nil {
+		log.Printf("failed to get the capabilities of %s: %v", address, err)
+		return
+	}
+	r.capsMu.Lock()
+	r.caps[address] = peerCapabilities{Capabilities: caps, fetched: time.Now()}
+	r.capsMu.Unlock()
+}
+
+// PeerCapabilities returns what the peer at address supports, as far as known.
+func (r *Relay) PeerCapabilities(address string) (ql.Capabilities, bool) {
+	r.capsMu.Lock()
+	defer r.capsMu.Unlock()
+	c, ok := r.caps[address]
+	return c.Capabilities, ok && c.Version > 0
+}
+
+// Capabilities returns the known capabilities of every peer, by address.
+func (r *Relay) Capabilities() map[string]ql.Capabilities {
+	r.capsMu.Lock()
+	defer r.capsMu.Unlock()
+	m := make(map[string]ql.Capabilities, len(r.caps))
+	for address, c := range r.caps {
+		if c.Version > 0 {
+			m[address] = c.Capabilities
+		}
+	}
+	return m
+}
 
 func (r *Relay) Init() error {
 	err := envconfig.Process("", r)
 	if err != nil {
 		return fmt.Errorf("couldn't process envconfig: %w", err)
 	}
+	if r.PublicURL == "" {
+		return fmt.Errorf("PUBLIC_URL is required to authenticate the clients")
+	}
+	log.Printf("Hub: clients authenticate for %s\n", r.PublicURL)
+
+	r.policy, err = policy.Load(r.PolicyFile)
+	if err != nil {
+		return fmt.Errorf("couldn't load the policy: %w", err)
+	}
 
 	// every hour, delete all very old events
 	go func() {
@@ -80,48 +242,57 @@ func (r *Relay) Init() error {
 		}
 	}()
 
-	go func() {
-		ticker := time.NewTicker(5 * time.Second)
-		for range ticker.C {
-			for k, e := range r.storage.Map {
-				ts := time.Now().Sub(e.LastUpdate)
-				tg := 5 * time.Second
-				if ts > tg {
-					delete(r.storage.Map, k)
-				}
-			}
-		}
-	}()
-
 	return nil
 }
 
+// AcceptEvent accepts every event, the policy is enforced by RejectEvent.
 func (r *Relay) AcceptEvent(evt *nostr.Event) bool {
-	// block events that are too large
-	jsonb, _ := json.Marshal(evt)
-	if len(jsonb) > 10000 {
-		return false
-	}
-
 	return true
 }
 
+func (r *Relay) RateLimits() relayer.RateLimits {
+	return r.Limits
+}
+
+// RejectEvent returns why the policy rejects evt, if it does.
+func (r *Relay) RejectEvent(evt *nostr.Event) error {
+	return r.policy.Check(evt)
+}
+
 func main() {
-	r := Relay{}
+	r := Relay{caps: make(map[string]peerCapabilities)}
 	if err := envconfig.Process("", &r); err != nil {
 		log.Fatalf("failed to read from env: %v", err)
 	}
-	tc, shutdown := trace.CreateTracers(trace.TracerConfig{
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		db := &postgresql.PostgresBackend{DatabaseURL: r.PostgresDatabase, ServiceName: r.Name()}
+		if err := db.MigrateCommand(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+	telemetry, shutdown, err := trace.Setup(trace.TracerConfig{
 		ServiceName:    r.Name(),
 		Environment:    r.Environment,
 		ServiceVersion: r.Version,
 		TraceExporter:  r.TraceExporter,
+		MetricExporter: r.MetricExporter,
+		LogExporter:    r.LogExporter,
+		SampleRatio:    r.TraceSampleRatio,
+		ParentBased:    r.TraceParentBased,
 	})
-	defer shutdown(context.Background())
+	if err != nil {
+		log.Fatalf("failed to set up telemetry: %v", err)
+	}
+	if telemetry.LogHook != nil {
+		relayer.AddLogHook(telemetry.LogHook)
+	}
+	tc := telemetry.Tracer
+	r.metrics = telemetry.Metrics
 	r.storage = &postgresql.PostgresBackend{
 		DatabaseURL: r.PostgresDatabase,
-		Map:         map[string]postgresql.PeerInfo{},
 		ServiceName: r.Name(),
+		PeerTTL:     r.PeerTTL,
 	}
 	ecdsaPvtKey, privKey, _, _, err := keys.GetKeys(r.Hex)
 	if err != nil {
@@ -132,20 +303,40 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to get host: %v", err)
 	}
+	r.outbox = outbox.New(func(address string, evt nostr.Event) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return r.ql.SaveEvent(ctx, address, &evt, nil)
+	}, func(pubkey string) int {
+		// only the peers serving pubkey ping for it
+		n := len(r.storage.GetPeers(pubkey, 0))
+		if n > r.Replicas {
+			n = r.Replicas
+		}
+		return n
+	})
 	hostAddr := p2pHost.GetMultiAddr(h)
 	log.Printf("Hub: listening on %s\n", hostAddr)
-	rpcHost := gorpc.NewServer(h, "/p2p/1.0.0")
-	pingService := ping.NewPingService(&r)
-	if err := rpcHost.Register(pingService); err != nil {
-		log.Fatalf("failed to register rpc server: %v", err)
+	r.rpc = gorpc.NewServer(h, ql.ProtocolV1)
+	var rs relayer.Settings
+	if err := envconfig.Process("", &rs); err != nil {
+		log.Fatalf("failed to read the relay settings from env: %v", err)
 	}
-	rs := relayer.Settings{Port: r.RelayPort}
+	rs.Port = r.RelayPort
 	i := ipfs.NewIPFSClient(r.IPFSNode, r.InfuraProjectID, r.InfuraProjectSecret)
 	if err != nil {
 		log.Fatalf("failed to up blob: %v", err)
 	}
-	go handler.Start(fmt.Sprintf(":%s", r.WebPort), r.storage.Map)
-	if err := relayer.StartConf(rs, &r, h, nil, ecdsaPvtKey, i, tc); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = relayer.StartConfContext(ctx, rs, &r, h, nil, ecdsaPvtKey, i, tc)
+	// the telemetry goes last, so that the shutdown is in it
+	sctx, cancel := context.WithTimeout(context.Background(), rs.ShutdownTimeout)
+	defer cancel()
+	if serr := shutdown(sctx); serr != nil {
+		log.Printf("failed to shut telemetry down: %v", serr)
+	}
+	if err != nil {
 		log.Fatalf("server terminated: %v", err)
 	}
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/hub/outbox"
+	"github.com/sithumonline/demedia-nostr/relayer/storage/postgresql"
+)
+
+func TestPeerOnlineDelivers(t *testing.T) {
+	url := os.Getenv("TEST_POSTGRESQL_DATABASE")
+	if url == "" {
+		t.Skip("TEST_POSTGRESQL_DATABASE is not set")
+	}
+	b := &postgresql.PostgresBackend{DatabaseURL: url, ServiceName: "test"}
+	if err := b.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		b.DB.Exec(`DELETE FROM outbox WHERE pubkey = 'peer-online'`)
+		b.DB.Close()
+	})
+
+	sent := make(chan string, 1)
+	o := outbox.New(func(address string, evt nostr.Event) error {
+		sent <- address + " " + evt.ID
+		return nil
+	}, func(string) int { return 1 })
+	if err := o.Init(b.DB); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Queue(nostr.Event{ID: "a", PubKey: "peer-online", Kind: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// fresh capabilities, so that none are fetched
+	r := &Relay{outbox: o, caps: map[string]peerCapabilities{"peer": {fetched: time.Now()}}}
+	r.PeerOnline("peer", []string{"peer-online"})
+	select {
+	case got := <-sent:
+		if got != "peer a" {
+			t.Errorf("sent %q; want %q", got, "peer a")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the queued event wasn't delivered")
+	}
+}
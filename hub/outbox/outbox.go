@@ -0,0 +1,209 @@
+package outbox
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer"
+	"github.com/sithumonline/demedia-nostr/relayer/ql"
+)
+
+const (
+	// Attempts made to deliver an event before it is dead-lettered.
+	maxAttempts = 5
+
+	// Backoff after the first failed delivery, doubled on every retry.
+	baseBackoff = time.Second
+
+	// Upper bound of the backoff between two deliveries.
+	maxBackoff = time.Minute
+)
+
+// SendFunc delivers evt to the peer at address.
+type SendFunc func(address string, evt nostr.Event) error
+
+// TargetFunc returns the number of peers that must get the events of pubkey
+// before they leave the outbox.
+type TargetFunc func(pubkey string) int
+
+// Outbox holds the events of offline peers in the hub database and delivers
+// them, in the order they were received, to every peer serving their pubkey
+// once it is back, until enough of them got the events.
+type Outbox struct {
+	db     *sqlx.DB
+	send   SendFunc
+	target TargetFunc
+	// waits out the backoff between two deliveries
+	sleep func(time.Duration)
+
+	mu         sync.Mutex
+	delivering map[string]bool
+}
+
+type Stats struct {
+	Pending map[string]int `json:"pending"`
+	Dead    int            `json:"dead"`
+}
+
+func New(send SendFunc, target TargetFunc) *Outbox {
+	return &Outbox{send: send, target: target, sleep: time.Sleep, delivering: make(map[string]bool)}
+}
+
+// Init sets the database holding the outbox table, created by the
+// migrations. It must be called before any other method.
+func (o *Outbox) Init(db *sqlx.DB) error {
+	o.db = db
+	return nil
+}
+
+func (o *Outbox) Queue(evt nostr.Event) error {
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	_, err = o.db.Exec(`INSERT INTO outbox (pubkey, event, created_at) VALUES ($1, $2, $3)`,
+		evt.PubKey, b, time.Now().Unix())
+	return err
+}
+
+// Deliver starts sending the queued events of pubkey to the peer at address,
+// unless a delivery of them to that peer is already running.
+func (o *Outbox) Deliver(pubkey string, address string) {
+	if o.db == nil {
+		return
+	}
+
+	key := pubkey + " " + address
+	o.mu.Lock()
+	if o.delivering[key] {
+		o.mu.Unlock()
+		return
+	}
+	o.delivering[key] = true
+	o.mu.Unlock()
+
+	go func() {
+		defer func() {
+			o.mu.Lock()
+			delete(o.delivering, key)
+			o.mu.Unlock()
+		}()
+		o.drain(pubkey, address)
+	}()
+}
+
+func (o *Outbox) drain(pubkey string, address string) {
+	log := relayer.DefaultLogger()
+	for {
+		var seq int64
+		var attempts int
+		var b []byte
+		err := o.db.QueryRow(`SELECT seq, attempts, event FROM outbox
+			WHERE pubkey = $1 AND NOT dead AND NOT ($2 = ANY(delivered)) ORDER BY seq LIMIT 1`,
+			pubkey, address).Scan(&seq, &attempts, &b)
+		if err == sql.ErrNoRows {
+			return
+		}
+		if err != nil {
+			log.Errorf("failed to read the outbox of %s: %v", pubkey, err)
+			return
+		}
+
+		var evt nostr.Event
+		if err := json.Unmarshal(b, &evt); err != nil {
+			if _, err := o.db.Exec(`UPDATE outbox SET dead = true, last_error = $2 WHERE seq = $1`,
+				seq, err.Error()); err != nil {
+				log.Errorf("failed to dead-letter queued event %d: %v", seq, err)
+				return
+			}
+			continue
+		}
+
+		err = o.send(address, evt)
+		if err == nil || strings.Contains(err.Error(), "duplicate:") {
+			log.Infof("delivered queued event %s to %s", evt.ID, address)
+			// the event leaves the outbox once enough peers have it
+			if _, err := o.db.Exec(`WITH d AS (
+				UPDATE outbox SET delivered = array_append(delivered, $2) WHERE seq = $1 RETURNING delivered
+			) DELETE FROM outbox WHERE seq = $1 AND (SELECT cardinality(delivered) FROM d) >= $3`,
+				seq, address, o.target(pubkey)); err != nil {
+				log.Errorf("failed to mark event %s delivered to %s: %v", evt.ID, address, err)
+				return
+			}
+			continue
+		}
+
+		if ql.IsUnreachable(err) {
+			// gone again, wait for the next ping; it doesn't count as an attempt
+			log.Warningf("peer %s went away while delivering event %s: %v", address, evt.ID, err)
+			if _, err := o.db.Exec(`UPDATE outbox SET last_error = $2 WHERE seq = $1`, seq, err.Error()); err != nil {
+				log.Errorf("failed to update queued event %s: %v", evt.ID, err)
+			}
+			return
+		}
+
+		attempts++
+		if attempts >= maxAttempts {
+			log.Errorf("dead-lettering event %s after %d attempts: %v", evt.ID, attempts, err)
+			if _, err := o.db.Exec(`UPDATE outbox SET attempts = $2, last_error = $3, dead = true WHERE seq = $1`,
+				seq, attempts, err.Error()); err != nil {
+				log.Errorf("failed to dead-letter event %s: %v", evt.ID, err)
+				return
+			}
+			continue
+		}
+		if _, err := o.db.Exec(`UPDATE outbox SET attempts = $2, last_error = $3 WHERE seq = $1`,
+			seq, attempts, err.Error()); err != nil {
+			log.Errorf("failed to update queued event %s: %v", evt.ID, err)
+			return
+		}
+
+		wait := backoff(attempts)
+		log.Warningf("failed to deliver event %s, retrying in %s: %v", evt.ID, wait, err)
+		o.sleep(wait)
+	}
+}
+
+// backoff returns the wait before the delivery following the attempts failed
+// ones.
+func backoff(attempts int) time.Duration {
+	if attempts > 6 {
+		// 1s << 6 is past maxBackoff already, and larger shifts overflow
+		return maxBackoff
+	}
+	wait := baseBackoff << (attempts - 1)
+	if wait > maxBackoff {
+		return maxBackoff
+	}
+	return wait
+}
+
+// Stats returns the number of queued events per pubkey and of dead letters.
+func (o *Outbox) Stats() (Stats, error) {
+	stats := Stats{Pending: map[string]int{}}
+	if o.db == nil {
+		return stats, nil
+	}
+
+	rows, err := o.db.Query(`SELECT pubkey, count(*) FROM outbox WHERE NOT dead GROUP BY pubkey`)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pubkey string
+		var n int
+		if err := rows.Scan(&pubkey, &n); err != nil {
+			return stats, err
+		}
+		stats.Pending[pubkey] = n
+	}
+
+	err = o.db.QueryRow(`SELECT count(*) FROM outbox WHERE dead`).Scan(&stats.Dead)
+	return stats, err
+}
@@ -0,0 +1,148 @@
+package outbox
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/storage/postgresql"
+)
+
+const testPubkey = "outbox-test"
+
+// testOutbox returns an outbox on the database in TEST_POSTGRESQL_DATABASE,
+// sending through send and recording its backoffs instead of sleeping. The
+// tests that need one are skipped when it is not set.
+func testOutbox(t *testing.T, send SendFunc) (*Outbox, *[]time.Duration) {
+	url := os.Getenv("TEST_POSTGRESQL_DATABASE")
+	if url == "" {
+		t.Skip("TEST_POSTGRESQL_DATABASE is not set")
+	}
+	b := &postgresql.PostgresBackend{DatabaseURL: url, ServiceName: "test"}
+	if err := b.Init(); err != nil {
+		t.Fatal(err)
+	}
+	clean := func() { b.DB.Exec(`DELETE FROM outbox WHERE pubkey = $1`, testPubkey) }
+	clean()
+	t.Cleanup(func() {
+		clean()
+		b.DB.Close()
+	})
+
+	o := New(send, func(string) int { return 1 })
+	var mu sync.Mutex
+	var slept []time.Duration
+	o.sleep = func(d time.Duration) {
+		mu.Lock()
+		slept = append(slept, d)
+		mu.Unlock()
+	}
+	if err := o.Init(b.DB); err != nil {
+		t.Fatal(err)
+	}
+	return o, &slept
+}
+
+// deliver runs a delivery of the test pubkey to address and waits for it.
+func deliver(t *testing.T, o *Outbox, address string) {
+	o.Deliver(testPubkey, address)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		o.mu.Lock()
+		running := len(o.delivering) > 0
+		o.mu.Unlock()
+		if !running {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("delivery still running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func queue(t *testing.T, o *Outbox, ids ...string) {
+	for _, id := range ids {
+		if err := o.Queue(nostr.Event{ID: id, PubKey: testPubkey, Kind: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		16 * time.Second, 32 * time.Second, time.Minute, time.Minute}
+	for i, w := range want {
+		if got := backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %s; want %s", i+1, got, w)
+		}
+	}
+	if got := backoff(100); got != maxBackoff {
+		t.Errorf("backoff(100) = %s; want %s", got, maxBackoff)
+	}
+}
+
+func TestDeliverInOrder(t *testing.T) {
+	var sent []string
+	o, _ := testOutbox(t, func(address string, evt nostr.Event) error {
+		sent = append(sent, address+" "+evt.ID)
+		return nil
+	})
+	queue(t, o, "a", "b", "c")
+
+	deliver(t, o, "peer")
+	if want := []string{"peer a", "peer b", "peer c"}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent %v; want %v", sent, want)
+	}
+	stats, err := o.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := stats.Pending[testPubkey]; n != 0 {
+		t.Errorf("%d events pending after the delivery; want 0", n)
+	}
+}
+
+func TestDeliverDeadLetters(t *testing.T) {
+	attempts := map[string]int{}
+	o, slept := testOutbox(t, func(address string, evt nostr.Event) error {
+		attempts[evt.ID]++
+		if evt.ID == "bad" {
+			return errors.New("error: rejected")
+		}
+		return nil
+	})
+	queue(t, o, "bad", "good")
+
+	deliver(t, o, "peer")
+	if attempts["bad"] != maxAttempts || attempts["good"] != 1 {
+		t.Errorf("attempts %v; want %d for bad and 1 for good", attempts, maxAttempts)
+	}
+	if want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}; !reflect.DeepEqual(*slept, want) {
+		t.Errorf("backoffs %v; want %v", *slept, want)
+	}
+	stats, err := o.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Dead < 1 || stats.Pending[testPubkey] != 0 {
+		t.Errorf("stats %+v; want the bad event dead and none pending", stats)
+	}
+}
+
+func TestStats(t *testing.T) {
+	o, _ := testOutbox(t, func(string, nostr.Event) error { return nil })
+	queue(t, o, "a", "b")
+
+	stats, err := o.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := stats.Pending[testPubkey]; n != 2 {
+		t.Errorf("%d events pending; want 2", n)
+	}
+}
@@ -3,34 +3,107 @@ package ping
 import (
 	"context"
 	"encoding/json"
-	"strings"
+	"fmt"
+	"sync"
+	"time"
 
+	gorpc "github.com/libp2p/go-libp2p-gorpc"
 	"github.com/sithumonline/demedia-nostr/relayer"
 	"github.com/sithumonline/demedia-nostr/relayer/ql"
 )
 
+// maxClockDrift is how far a registration timestamp may be from the hub's clock.
+const maxClockDrift = 30 * time.Second
+
+// Watcher is implemented by relays that react to peers pinging the hub.
+type Watcher interface {
+	// PeerOnline is called after every accepted ping with the pubkeys the peer hosts.
+	PeerOnline(address string, pubkeys []string)
+}
+
 type PingService struct {
 	relay relayer.Relay
+
+	// last accepted registration timestamp per pubkey, used to reject replays
+	seenMu sync.Mutex
+	seen   map[string]int64
 }
 
 func NewPingService(relay relayer.Relay) *PingService {
-	return &PingService{relay: relay}
+	return &PingService{relay: relay, seen: make(map[string]int64)}
 }
 
-func (t *PingService) Ping(_ context.Context, argType ql.BridgeArgs, replyType *ql.BridgeReply) error {
+func (t *PingService) Ping(ctx context.Context, argType ql.BridgeArgs, replyType *ql.BridgeReply) error {
 	call := ql.BridgeCall{}
 	err := json.Unmarshal(argType.Data, &call)
 	if err != nil {
 		return err
 	}
 
-	data := strings.Trim(string(call.Body), "\\\"")
+	var reg ql.Registration
+	if err := json.Unmarshal(call.Body, &reg); err != nil {
+		return fmt.Errorf("registration rejected: %w", err)
+	}
 	logger := relayer.DefaultLogger()
-	logger.CustomLevel("ping", "Received a Ping call, message: %s", data)
+	logger.CustomLevel("ping", "Received a Ping call, pubkey: %s address: %s", reg.PubKey, reg.Address)
 
-	adds := strings.Split(data, ";")
-	t.relay.Storage().SavePeer(adds[1], adds[0])
+	if err := t.check(ctx, &reg); err != nil {
+		logger.Warningf("rejected registration of %s from %s: %v", reg.PubKey, reg.PeerID, err)
+		return fmt.Errorf("registration rejected: %w", err)
+	}
+
+	hosted, err := reg.HostedPubKeys()
+	if err != nil {
+		logger.Warningf("ignoring consents in registration of %s: %v", reg.PubKey, err)
+	}
+	for _, pubKey := range hosted {
+		t.relay.Storage().SavePeer(reg.Address, pubKey)
+	}
+	if w, ok := t.relay.(Watcher); ok {
+		w.PeerOnline(reg.Address, hosted)
+	}
 
 	replyType.Data = []byte("Pong")
 	return nil
 }
+
+// Expire forgets the registrations too old to be replayed, they are rejected
+// as stale anyway. It is called along with the Expire of the peer registry,
+// which drops the peers whose consents expired as they are no longer renewed.
+func (t *PingService) Expire(now time.Time) {
+	t.seenMu.Lock()
+	defer t.seenMu.Unlock()
+	for pubkey, createdAt := range t.seen {
+		if now.Sub(time.Unix(createdAt, 0)) > maxClockDrift {
+			delete(t.seen, pubkey)
+		}
+	}
+}
+
+func (t *PingService) check(ctx context.Context, reg *ql.Registration) error {
+	if err := reg.Verify(); err != nil {
+		return err
+	}
+
+	sender, err := gorpc.GetRequestSender(ctx)
+	if err != nil {
+		return err
+	}
+	if sender.String() != reg.PeerID {
+		return fmt.Errorf("peer id %s does not match the connection %s", reg.PeerID, sender)
+	}
+
+	drift := time.Since(time.Unix(reg.CreatedAt, 0))
+	if drift > maxClockDrift || drift < -maxClockDrift {
+		return fmt.Errorf("stale registration, created_at is %s off", drift.Round(time.Second))
+	}
+
+	t.seenMu.Lock()
+	defer t.seenMu.Unlock()
+	if last, ok := t.seen[reg.PubKey]; ok && reg.CreatedAt <= last {
+		return fmt.Errorf("replayed registration")
+	}
+	t.seen[reg.PubKey] = reg.CreatedAt
+
+	return nil
+}
@@ -0,0 +1,21 @@
+package ping
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpire(t *testing.T) {
+	now := time.Now()
+	s := NewPingService(nil)
+	s.seen["old"] = now.Add(-maxClockDrift - time.Second).Unix()
+	s.seen["recent"] = now.Add(-time.Second).Unix()
+
+	s.Expire(now)
+	if _, ok := s.seen["old"]; ok {
+		t.Error("a registration past the clock drift is still remembered")
+	}
+	if _, ok := s.seen["recent"]; !ok {
+		t.Error("a registration that could be replayed was forgotten")
+	}
+}
@@ -2,11 +2,28 @@ package ipfs
 
 import (
 	"bytes"
+	"context"
 	"io/ioutil"
 
 	shell "github.com/ipfs/go-ipfs-api"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
 )
 
+var (
+	uploadSize     metric.Int64Histogram
+	uploadFailures metric.Int64Counter
+)
+
+func init() {
+	meter := otel.Meter("github.com/sithumonline/demedia-nostr/ipfs")
+	uploadSize, _ = meter.Int64Histogram("ipfs.upload.size",
+		metric.WithDescription("Size of the files uploaded to IPFS"),
+		metric.WithUnit("By"))
+	uploadFailures, _ = meter.Int64Counter("ipfs.upload.failures",
+		metric.WithDescription("Files that failed to upload to IPFS"))
+}
+
 type IPFSClient struct {
 	sh *shell.Shell
 }
@@ -23,8 +40,10 @@ func NewIPFSClient(apiAddress, projectId, projectSecret string) *IPFSClient {
 func (c *IPFSClient) UploadFile(data []byte) (string, error) {
 	cid, err := c.sh.Add(bytes.NewReader(data))
 	if err != nil {
+		uploadFailures.Add(context.Background(), 1)
 		return "", err
 	}
+	uploadSize.Record(context.Background(), int64(len(data)))
 	return "https://gateway.ipfs.io/ipfs/" + cid, nil
 }
 
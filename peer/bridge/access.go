@@ -0,0 +1,96 @@
+package bridge
+
+import (
+	"context"
+
+	rpc "github.com/libp2p/go-libp2p-gorpc"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer"
+	"github.com/sithumonline/demedia-nostr/relayer/ql"
+	"golang.org/x/exp/slices"
+)
+
+// KindRestricter is implemented by relays that keep other kinds than kind-4
+// private, their events are only served to their author and to the pubkeys
+// they tag.
+type KindRestricter interface {
+	PrivateKinds() []int
+}
+
+// HubTruster is implemented by relays that know the hub they serve. Only the
+// calls of that hub are trusted with the pubkey of the client they are made
+// for, see [ql.Meta], the other callers are unauthenticated.
+type HubTruster interface {
+	TrustsHub(id peer.ID) bool
+}
+
+// fromHub reports whether the call of ctx was made by a hub the relay trusts.
+func (t *BridgeService) fromHub(ctx context.Context) bool {
+	truster, ok := t.relay.(HubTruster)
+	if !ok {
+		return false
+	}
+	id, err := rpc.GetRequestSender(ctx)
+	if err != nil {
+		return false
+	}
+	return truster.TrustsHub(id)
+}
+
+// privateKinds returns the kinds of relay only served to the pubkey the hub
+// authenticated, see [ql.Authed].
+func privateKinds(relay relayer.Relay) []int {
+	if r, ok := relay.(KindRestricter); ok {
+		return r.PrivateKinds()
+	}
+	return []int{4}
+}
+
+// canRead reports whether evt may be served to the client the call is made
+// for.
+func (t *BridgeService) canRead(ctx context.Context, evt *nostr.Event) bool {
+	return readable(t.relay, ctx, evt)
+}
+
+// readable reports whether evt of relay may be served to the client of ctx.
+func readable(relay relayer.Relay, ctx context.Context, evt *nostr.Event) bool {
+	if !slices.Contains(privateKinds(relay), evt.Kind) {
+		return true
+	}
+	authed := ql.Authed(ctx)
+	if authed == "" {
+		return false
+	}
+	return evt.PubKey == authed || evt.Tags.GetFirst([]string{"p", authed}) != nil
+}
+
+// restrictFilter returns an error when filter asks for private kinds that
+// aren't only sent by or to the client the call is made for. Their events are
+// left out of queries anyway, but they would be counted.
+func (t *BridgeService) restrictFilter(ctx context.Context, filter *nostr.Filter) error {
+	private := false
+	for _, kind := range privateKinds(t.relay) {
+		if slices.Contains(filter.Kinds, kind) {
+			private = true
+			break
+		}
+	}
+	if !private {
+		return nil
+	}
+
+	authed := ql.Authed(ctx)
+	senders := filter.Authors
+	receivers := filter.Tags["p"]
+	switch {
+	case authed == "":
+		return ql.NewError(ql.CodeRestricted, "private kinds are only served to authenticated users")
+	case len(senders) == 1 && len(receivers) < 2 && senders[0] == authed:
+		return nil
+	case len(receivers) == 1 && len(senders) < 2 && receivers[0] == authed:
+		return nil
+	default:
+		return ql.NewError(ql.CodeRestricted, "authenticated user does not have authorization for requested filters")
+	}
+}
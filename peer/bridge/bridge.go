@@ -8,18 +8,27 @@ import (
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/sithumonline/demedia-nostr/relayer"
 	"github.com/sithumonline/demedia-nostr/relayer/ql"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
 )
 
+// Hoster is implemented by relays that only store the events of the pubkeys they host.
+type Hoster interface {
+	Hosts(pubkey string) bool
+}
+
 type BridgeService struct {
 	relay  relayer.Relay
 	tracer trace.Tracer
+	live   *LiveService
 }
 
-func NewBridgeService(relay relayer.Relay, tc trace.Tracer) *BridgeService {
-	return &BridgeService{relay: relay, tracer: tc}
+// NewBridgeService creates the service the hubs call. Saved events are
+// published to live, which may be nil.
+func NewBridgeService(relay relayer.Relay, tc trace.Tracer, live *LiveService) *BridgeService {
+	return &BridgeService{relay: relay, tracer: tc, live: live}
 }
 
 func (t *BridgeService) Ql(ctx context.Context, argType ql.BridgeArgs, replyType *ql.BridgeReply) error {
@@ -44,8 +53,7 @@ func (t *BridgeService) Ql(ctx context.Context, argType ql.BridgeArgs, replyType
 		if err != nil {
 			return err
 		}
-		log.InfofWithContext(ctx, "Received a saveEvent call, event: %s", d.ID)
-		return t.relay.Storage().SaveEvent(&d)
+		return t.saveEvent(ctx, &d)
 	case "queryEvents":
 		ctx, span := t.tracer.Start(ctx, "ql.method.queryEvents")
 		span.SetAttributes(attribute.String("span_id", span.SpanContext().SpanID().String()))
@@ -55,8 +63,7 @@ func (t *BridgeService) Ql(ctx context.Context, argType ql.BridgeArgs, replyType
 		if err != nil {
 			return err
 		}
-		log.InfofWithContext(ctx, "Received a queryEvents call")
-		events, err := t.relay.Storage().QueryEvents(&d)
+		events, err := t.queryEvents(ctx, &d)
 		if err != nil {
 			return err
 		}
@@ -76,10 +83,203 @@ func (t *BridgeService) Ql(ctx context.Context, argType ql.BridgeArgs, replyType
 		if err != nil {
 			return err
 		}
-		log.InfofWithContext(ctx, "Received a deleteEvent call, event: %s", d.ID)
-		return t.relay.Storage().DeleteEvent(d.ID, d.PubKey)
+		return t.deleteEvent(ctx, d.ID, d.PubKey)
+	case "countEvents":
+		ctx, span := t.tracer.Start(ctx, "ql.method.countEvents")
+		span.SetAttributes(attribute.String("span_id", span.SpanContext().SpanID().String()))
+		defer span.End()
+		var d nostr.Filter
+		err := json.Unmarshal(call.Body, &d)
+		if err != nil {
+			return err
+		}
+		count, err := t.countEvents(ctx, &d)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(count)
+		if err != nil {
+			return err
+		}
+		replyType.Data = b
+		return nil
 	default:
 		log.InfofWithContext(ctx, "Received a call, method: %s", call.Method)
 		return errors.New("method not found")
 	}
 }
+
+// QlStream is the streaming counterpart of Ql. It reads a single call from in
+// and sends one reply per result, so large queries are never held in memory
+// as a whole. Only "queryEvents" is streamed.
+func (t *BridgeService) QlStream(ctx context.Context, in <-chan ql.BridgeArgs, out chan<- ql.BridgeReply) error {
+	defer close(out)
+
+	argType, ok := <-in
+	if !ok {
+		return errors.New("no call received")
+	}
+	call := ql.BridgeCall{}
+	err := json.Unmarshal(argType.Data, &call)
+	if err != nil {
+		return err
+	}
+	ctx = propagation.TraceContext{}.Extract(ctx, call.Carrier)
+	ctx, span := t.tracer.Start(ctx, "ql.stream")
+	span.SetAttributes(attribute.String("span_id", span.SpanContext().SpanID().String()))
+	defer span.End()
+	log := relayer.DefaultLogger()
+	log.InfofWithContext(ctx, "Received a QlStream call, method: %s", call.Method)
+	if call.Method != "queryEvents" {
+		return errors.New("method not found")
+	}
+
+	var d nostr.Filter
+	if err := json.Unmarshal(call.Body, &d); err != nil {
+		return err
+	}
+
+	return t.streamEvents(ctx, &d, func(evt nostr.Event) error {
+		b, err := json.Marshal(evt)
+		if err != nil {
+			return err
+		}
+		select {
+		case out <- ql.BridgeReply{Data: b}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+func (t *BridgeService) saveEvent(ctx context.Context, evt *nostr.Event) error {
+	log := relayer.DefaultLogger()
+	log.InfofWithContext(ctx, "Received a saveEvent call, event: %s", evt.ID)
+	if h, ok := t.relay.(Hoster); ok && !h.Hosts(evt.PubKey) {
+		log.WarningfWithContext(ctx, "refusing event %s of pubkey %s", evt.ID, evt.PubKey)
+		return ql.NewError(ql.CodeBlocked, "pubkey %s is not hosted by this peer", evt.PubKey)
+	}
+	// the hub may not have the same policy
+	if err := relayer.CheckEvent(t.relay, evt); err != nil {
+		log.WarningfWithContext(ctx, "refusing event %s: %v", evt.ID, err)
+		return err
+	}
+	if err := relayer.StorageWithContext(t.relay.Storage()).SaveEventCtx(ctx, evt); err != nil {
+		return err
+	}
+	if t.live != nil {
+		t.live.Publish(*evt)
+	}
+	return nil
+}
+
+func (t *BridgeService) queryEvents(ctx context.Context, filter *nostr.Filter) ([]nostr.Event, error) {
+	relayer.DefaultLogger().InfofWithContext(ctx, "Received a queryEvents call")
+	events, err := relayer.StorageWithContext(t.relay.Storage()).QueryEventsCtx(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	readable := events[:0]
+	for i := range events {
+		if t.canRead(ctx, &events[i]) {
+			readable = append(readable, events[i])
+		}
+	}
+	return readable, nil
+}
+
+// streamEvents calls send for each event matching filter, without loading
+// them all when the storage can stream them.
+func (t *BridgeService) streamEvents(ctx context.Context, filter *nostr.Filter, send func(nostr.Event) error) error {
+	log := relayer.DefaultLogger()
+	sent := 0
+	counted := func(evt nostr.Event) error {
+		if !t.canRead(ctx, &evt) {
+			return nil
+		}
+		if err := send(evt); err != nil {
+			return err
+		}
+		sent++
+		return nil
+	}
+
+	var err error
+	if streamer, ok := t.relay.Storage().(relayer.EventStreamer); ok {
+		err = streamer.StreamEvents(ctx, filter, counted)
+	} else {
+		var events []nostr.Event
+		events, err = relayer.StorageWithContext(t.relay.Storage()).QueryEventsCtx(ctx, filter)
+		for _, evt := range events {
+			if err = counted(evt); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	log.InfofWithContext(ctx, "Streamed %d events", sent)
+	return nil
+}
+
+func (t *BridgeService) deleteEvent(ctx context.Context, id string, pubkey string) error {
+	relayer.DefaultLogger().InfofWithContext(ctx, "Received a deleteEvent call, event: %s", id)
+	return relayer.StorageWithContext(t.relay.Storage()).DeleteEventCtx(ctx, id, pubkey)
+}
+
+func (t *BridgeService) countEvents(ctx context.Context, filter *nostr.Filter) (int64, error) {
+	relayer.DefaultLogger().InfofWithContext(ctx, "Received a countEvents call")
+	counter, ok := t.relay.Storage().(relayer.Counter)
+	if !ok {
+		return 0, ql.NewError(ql.CodeUnknownMethod, "this peer can't count events")
+	}
+	if err := t.restrictFilter(ctx, filter); err != nil {
+		return 0, err
+	}
+	return counter.CountEvents(ctx, filter)
+}
+
+func (t *BridgeService) searchEvents(ctx context.Context, filter *nostr.Filter, search string) ([]storage.SearchResult, error) {
+	relayer.DefaultLogger().InfofWithContext(ctx, "Received a searchEvents call")
+	searcher, ok := t.relay.Storage().(relayer.Searcher)
+	if !ok {
+		return nil, ql.NewError(ql.CodeUnknownMethod, "this peer can't search events")
+	}
+	results, err := searcher.SearchEvents(ctx, filter, search)
+	if err != nil {
+		return nil, err
+	}
+	readable := results[:0]
+	for _, r := range results {
+		if t.canRead(ctx, &r.Event) {
+			readable = append(readable, r)
+		}
+	}
+	return readable, nil
+}
+
+// capabilities lists what this peer supports, see [ql.Capabilities].
+func (t *BridgeService) capabilities() ql.Capabilities {
+	caps := ql.Capabilities{
+		Version: 2,
+		Methods: []string{
+			ql.MethodCapabilities,
+			ql.MethodSaveEvent,
+			ql.MethodQueryEvents,
+			ql.MethodStreamEvents,
+			ql.MethodDeleteEvent,
+		},
+		NIPs: []int{9},
+	}
+	if _, ok := t.relay.Storage().(relayer.Counter); ok {
+		caps.Methods = append(caps.Methods, ql.MethodCountEvents)
+		caps.NIPs = append(caps.NIPs, 45)
+	}
+	if _, ok := t.relay.Storage().(relayer.Searcher); ok {
+		caps.Methods = append(caps.Methods, ql.MethodSearchEvents)
+		caps.NIPs = append(caps.NIPs, 50)
+	}
+	return caps
+}
@@ -0,0 +1,91 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer"
+	"github.com/sithumonline/demedia-nostr/relayer/ql"
+)
+
+// events buffered per stream before the slowest hubs start missing some
+const liveBufferSize = 256
+
+// LiveService streams the events saved on this peer to the hubs, for their
+// subscriptions to receive them as they arrive. It is served on [ql.LiveProtocol].
+type LiveService struct {
+	relay relayer.Relay
+
+	mu   sync.Mutex
+	subs map[chan nostr.Event]struct{}
+}
+
+func NewLiveService(relay relayer.Relay) *LiveService {
+	return &LiveService{relay: relay, subs: make(map[chan nostr.Event]struct{})}
+}
+
+// Publish hands evt to every open stream. Streams that are not keeping up
+// miss it rather than holding back the caller.
+func (t *LiveService) Publish(evt nostr.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for ch := range t.subs {
+		select {
+		case ch <- evt:
+		default:
+		}
+	}
+}
+
+// Subscribe receives the hub filters as JSON, each message replacing the
+// previous ones, and sends back the published events matching them until
+// the hub closes the stream.
+func (t *LiveService) Subscribe(ctx context.Context, in <-chan ql.BridgeArgs, out chan<- ql.BridgeReply) error {
+	defer close(out)
+
+	ch := make(chan nostr.Event, liveBufferSize)
+	t.mu.Lock()
+	t.subs[ch] = struct{}{}
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.subs, ch)
+		t.mu.Unlock()
+	}()
+
+	log := relayer.DefaultLogger()
+	var filters nostr.Filters
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case args, ok := <-in:
+			if !ok {
+				return nil
+			}
+			var f nostr.Filters
+			if err := json.Unmarshal(args.Data, &f); err != nil {
+				log.Warningf("ignoring invalid live filters: %v", err)
+				continue
+			}
+			filters = f
+		case evt := <-ch:
+			// the stream is shared by all the clients of the hub, no one
+			// is authenticated on it and private kinds are left out
+			if !filters.Match(&evt) || !readable(t.relay, ctx, &evt) {
+				continue
+			}
+			b, err := json.Marshal(evt)
+			if err != nil {
+				continue
+			}
+			select {
+			case out <- ql.BridgeReply{Data: b}:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
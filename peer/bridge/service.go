@@ -0,0 +1,105 @@
+package bridge
+
+import (
+	"context"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/ql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// QlService serves [ql.ProtocolV2], the typed counterpart of BridgeService.
+// Failures are answered in the Error field of the responses, so that the hub
+// gets their [ql.ErrorCode].
+type QlService struct {
+	bridge *BridgeService
+}
+
+func NewQlService(bridge *BridgeService) *QlService {
+	return &QlService{bridge: bridge}
+}
+
+func (t *QlService) start(ctx context.Context, meta ql.Meta, name string) (context.Context, trace.Span) {
+	ctx = propagation.TraceContext{}.Extract(ctx, meta.Carrier)
+	// anyone can dial the peer, only the hub vouches for its clients
+	if t.bridge.fromHub(ctx) {
+		ctx = ql.WithAuthed(ctx, meta.Authed)
+	}
+	ctx, span := t.bridge.tracer.Start(ctx, name)
+	span.SetAttributes(attribute.String("span_id", span.SpanContext().SpanID().String()))
+	return ctx, span
+}
+
+func (t *QlService) Capabilities(ctx context.Context, req ql.CapabilitiesRequest, resp *ql.Capabilities) error {
+	*resp = t.bridge.capabilities()
+	return nil
+}
+
+func (t *QlService) SaveEvent(ctx context.Context, req ql.SaveEventRequest, resp *ql.SaveEventResponse) error {
+	ctx, span := t.start(ctx, req.Meta, "ql.v2.SaveEvent")
+	defer span.End()
+	resp.Error = ql.AsError(t.bridge.saveEvent(ctx, &req.Event))
+	return nil
+}
+
+func (t *QlService) QueryEvents(ctx context.Context, req ql.QueryEventsRequest, resp *ql.QueryEventsResponse) error {
+	ctx, span := t.start(ctx, req.Meta, "ql.v2.QueryEvents")
+	defer span.End()
+	events, err := t.bridge.queryEvents(ctx, &req.Filter)
+	resp.Events = events
+	resp.Error = ql.AsError(err)
+	return nil
+}
+
+// StreamEvents reads a single request from in and answers it with one reply
+// per event. A failure is sent as the last reply.
+func (t *QlService) StreamEvents(ctx context.Context, in <-chan ql.QueryEventsRequest, out chan<- ql.StreamEventsReply) error {
+	defer close(out)
+
+	req, ok := <-in
+	if !ok {
+		return nil
+	}
+	ctx, span := t.start(ctx, req.Meta, "ql.v2.StreamEvents")
+	defer span.End()
+
+	err := t.bridge.streamEvents(ctx, &req.Filter, func(evt nostr.Event) error {
+		select {
+		case out <- ql.StreamEventsReply{Event: &evt}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if err != nil && ctx.Err() == nil {
+		out <- ql.StreamEventsReply{Error: ql.AsError(err)}
+	}
+	return nil
+}
+
+func (t *QlService) DeleteEvent(ctx context.Context, req ql.DeleteEventRequest, resp *ql.DeleteEventResponse) error {
+	ctx, span := t.start(ctx, req.Meta, "ql.v2.DeleteEvent")
+	defer span.End()
+	resp.Error = ql.AsError(t.bridge.deleteEvent(ctx, req.ID, req.PubKey))
+	return nil
+}
+
+func (t *QlService) CountEvents(ctx context.Context, req ql.CountEventsRequest, resp *ql.CountEventsResponse) error {
+	ctx, span := t.start(ctx, req.Meta, "ql.v2.CountEvents")
+	defer span.End()
+	count, err := t.bridge.countEvents(ctx, &req.Filter)
+	resp.Count = count
+	resp.Error = ql.AsError(err)
+	return nil
+}
+
+func (t *QlService) SearchEvents(ctx context.Context, req ql.SearchEventsRequest, resp *ql.SearchEventsResponse) error {
+	ctx, span := t.start(ctx, req.Meta, "ql.v2.SearchEvents")
+	defer span.End()
+	results, err := t.bridge.searchEvents(ctx, &req.Filter, req.Search)
+	resp.Results = results
+	resp.Error = ql.AsError(err)
+	return nil
+}
@@ -0,0 +1,209 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	gorpc "github.com/libp2p/go-libp2p-gorpc"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer"
+	"github.com/sithumonline/demedia-nostr/relayer/ql"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type testRelay struct {
+	storage *testStorage
+	hosted  string
+	hub     peer.ID
+}
+
+func (r *testRelay) Name() string                  { return "test" }
+func (r *testRelay) Init() error                   { return nil }
+func (r *testRelay) OnInitialized(*relayer.Server) {}
+func (r *testRelay) AcceptEvent(*nostr.Event) bool { return true }
+func (r *testRelay) Storage() relayer.Storage      { return r.storage }
+func (r *testRelay) Hosts(pubkey string) bool      { return pubkey == r.hosted }
+func (r *testRelay) TrustsHub(id peer.ID) bool     { return id == r.hub }
+
+type testStorage struct {
+	events map[string]nostr.Event
+}
+
+func (s *testStorage) Init() error                            { return nil }
+func (s *testStorage) SavePeer(address string, pubkey string) {}
+func (s *testStorage) GetPeer(pubkey string) string           { return "" }
+
+func (s *testStorage) QueryEvents(filter *nostr.Filter) ([]nostr.Event, error) {
+	var events []nostr.Event
+	for _, evt := range s.events {
+		if filter.Matches(&evt) {
+			events = append(events, evt)
+		}
+	}
+	return events, nil
+}
+
+func (s *testStorage) DeleteEvent(id string, pubkey string) error {
+	delete(s.events, id)
+	return nil
+}
+
+func (s *testStorage) SaveEvent(evt *nostr.Event) error {
+	if _, ok := s.events[evt.ID]; ok {
+		return storage.ErrDupEvent
+	}
+	s.events[evt.ID] = *evt
+	return nil
+}
+
+func newTestHost(t *testing.T) host.Host {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+// newTestPeer starts a peer serving ProtocolV1, and ProtocolV2 when v2 is set.
+// It trusts the calls of hub with authenticated clients.
+func newTestPeer(t *testing.T, v2 bool, hub peer.ID) (string, *testStorage) {
+	st := &testStorage{events: make(map[string]nostr.Event)}
+	relay := &testRelay{storage: st, hosted: "alice", hub: hub}
+	bridge := NewBridgeService(relay, trace.NewNoopTracerProvider().Tracer("test"), nil)
+
+	h := newTestHost(t)
+	if err := gorpc.NewServer(h, ql.ProtocolV1).Register(bridge); err != nil {
+		t.Fatal(err)
+	}
+	if v2 {
+		if err := gorpc.NewServer(h, ql.ProtocolV2).Register(NewQlService(bridge)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return h.Addrs()[0].String() + "/p2p/" + h.ID().String(), st
+}
+
+func TestQlProtocols(t *testing.T) {
+	for _, v2 := range []bool{true, false} {
+		address, st := newTestPeer(t, v2, "")
+		client := ql.NewClient(newTestHost(t))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		caps, err := client.GetCapabilities(ctx, address)
+		if err != nil {
+			t.Fatalf("v2=%v: capabilities: %v", v2, err)
+		}
+		if want := map[bool]int{true: 2, false: 1}[v2]; caps.Version != want {
+			t.Errorf("v2=%v: got version %d, want %d", v2, caps.Version, want)
+		}
+
+		evt := nostr.Event{ID: "1", PubKey: "alice", Kind: 1, CreatedAt: time.Unix(1000, 0), Tags: nostr.Tags{{"t", "x"}}}
+		if err := client.SaveEvent(ctx, address, &evt, nil); err != nil {
+			t.Fatalf("v2=%v: save: %v", v2, err)
+		}
+		if got := st.events["1"]; got.PubKey != "alice" || !got.CreatedAt.Equal(evt.CreatedAt) || len(got.Tags) != 1 {
+			t.Errorf("v2=%v: saved %+v", v2, got)
+		}
+
+		err = client.SaveEvent(ctx, address, &evt, nil)
+		if qe := ql.AsError(err); err == nil || qe.Code != ql.CodeDuplicate {
+			t.Errorf("v2=%v: got %v, want a duplicate error", v2, err)
+		}
+		bob := nostr.Event{ID: "2", PubKey: "bob", Kind: 1}
+		err = client.SaveEvent(ctx, address, &bob, nil)
+		if qe := ql.AsError(err); err == nil || qe.Code != ql.CodeBlocked {
+			t.Errorf("v2=%v: got %v, want a blocked error", v2, err)
+		}
+
+		filter := nostr.Filter{Authors: []string{"alice"}}
+		events, err := client.QueryEvents(ctx, address, &filter, nil)
+		if err != nil || len(events) != 1 {
+			t.Errorf("v2=%v: query got %v, %v", v2, events, err)
+		}
+
+		ch := make(chan nostr.Event)
+		done := make(chan error, 1)
+		go func() { done <- client.StreamEvents(ctx, address, &filter, nil, ch) }()
+		n := 0
+		for range ch {
+			n++
+		}
+		if err := <-done; err != nil || n != 1 {
+			t.Errorf("v2=%v: stream got %d events, %v", v2, n, err)
+		}
+
+		if err := client.DeleteEvent(ctx, address, "1", "alice", nil); err != nil {
+			t.Errorf("v2=%v: delete: %v", v2, err)
+		}
+		if len(st.events) != 0 {
+			t.Errorf("v2=%v: event not deleted", v2)
+		}
+	}
+}
+
+func TestQlPrivateKinds(t *testing.T) {
+	hub := newTestHost(t)
+	address, st := newTestPeer(t, true, hub.ID())
+	st.events["dm"] = nostr.Event{ID: "dm", PubKey: "alice", Kind: 4, Tags: nostr.Tags{{"p", "bob"}}}
+	st.events["note"] = nostr.Event{ID: "note", PubKey: "alice", Kind: 1}
+	client := ql.NewClient(hub)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := nostr.Filter{Authors: []string{"alice"}}
+	for authed, want := range map[string]int{"": 1, "carol": 1, "bob": 2, "alice": 2} {
+		events, err := client.QueryEvents(ql.WithAuthed(ctx, authed), address, &filter, nil)
+		if err != nil || len(events) != want {
+			t.Errorf("authed %q: got %d events, %v, want %d", authed, len(events), err, want)
+		}
+	}
+
+	// any other node may claim to call for bob
+	other := ql.NewClient(newTestHost(t))
+	events, err := other.QueryEvents(ql.WithAuthed(ctx, "bob"), address, &filter, nil)
+	if err != nil || len(events) != 1 {
+		t.Errorf("not the hub: got %d events, %v, want 1", len(events), err)
+	}
+}
+
+func TestLivePrivateKinds(t *testing.T) {
+	st := &testStorage{events: make(map[string]nostr.Event)}
+	live := NewLiveService(&testRelay{storage: st, hosted: "alice"})
+	h := newTestHost(t)
+	if err := gorpc.NewServer(h, ql.LiveProtocol).Register(live); err != nil {
+		t.Fatal(err)
+	}
+	address := h.Addrs()[0].String() + "/p2p/" + h.ID().String()
+
+	client := ql.NewClient(newTestHost(t))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filters := make(chan ql.BridgeArgs, 1)
+	b, _ := json.Marshal(nostr.Filters{{Authors: []string{"alice"}}})
+	filters <- ql.BridgeArgs{Data: b}
+	events := make(chan ql.BridgeReply)
+	go client.Live(ctx, address, filters, events)
+
+	// until the filters are read, published events don't match any
+	go func() {
+		for ctx.Err() == nil {
+			live.Publish(nostr.Event{ID: "dm", PubKey: "alice", Kind: 4, Tags: nostr.Tags{{"p", "bob"}}})
+			live.Publish(nostr.Event{ID: "note", PubKey: "alice", Kind: 1})
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	var evt nostr.Event
+	json.Unmarshal((<-events).Data, &evt)
+	if evt.ID != "note" {
+		t.Errorf("got %s live, want only the public note", evt.ID)
+	}
+}
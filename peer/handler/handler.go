@@ -23,7 +23,7 @@ func Start(port string, relay relayer.Relay, pub *ecdsa.PublicKey) {
 	v1 := r.Group("/v1")
 	{
 		v1.GET("/data", func(c *gin.Context) {
-			events, err := relay.Storage().QueryEvents(&nostr.Filter{})
+			events, err := relayer.StorageWithContext(relay.Storage()).QueryEventsCtx(c.Request.Context(), &nostr.Filter{})
 			for _, event := range events {
 				if event.Kind == 1 && len(event.Tags) > 0 {
 					tag := len(event.Tags) - 1
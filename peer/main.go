@@ -6,13 +6,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcec/v2/schnorr"
 	"github.com/kelseyhightower/envconfig"
 	gorpc "github.com/libp2p/go-libp2p-gorpc"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/nbd-wtf/go-nostr"
 	p2pHost "github.com/sithumonline/demedia-nostr/host"
 	"github.com/sithumonline/demedia-nostr/keys"
@@ -20,15 +26,24 @@ import (
 	"github.com/sithumonline/demedia-nostr/peer/handler"
 	"github.com/sithumonline/demedia-nostr/port"
 	"github.com/sithumonline/demedia-nostr/relayer"
+	"github.com/sithumonline/demedia-nostr/relayer/policy"
 	"github.com/sithumonline/demedia-nostr/relayer/ql"
 	"github.com/sithumonline/demedia-nostr/relayer/storage/elasticsearch"
 	"github.com/sithumonline/demedia-nostr/relayer/storage/postgresql"
+	"github.com/sithumonline/demedia-nostr/relayer/storage/sqlite"
 	"github.com/sithumonline/demedia-nostr/trace"
 )
 
+// Time allowed for the hub to answer a ping.
+const pingTimeout = 5 * time.Second
+
 type Relay struct {
 	PostgresDatabase string `envconfig:"POSTGRESQL_DATABASE"`
 
+	// SQLitePath is the database file of peers that don't run a database
+	// server, it takes precedence over PostgresDatabase.
+	SQLitePath string `envconfig:"SQLITE_PATH" default:""`
+
 	storage relayer.Storage
 
 	host host.Host
@@ -41,8 +56,24 @@ type Relay struct {
 
 	BtcPubKey string
 
+	btcPvtKey *btcec.PrivateKey
+
+	// HostedConsents is a JSON file with the consent events of the other
+	// users this peer hosts, see ql.KindHostingConsent.
+	HostedConsents string `envconfig:"HOSTED_CONSENTS" default:""`
+
+	consents []nostr.Event
+
+	hosted map[string]struct{}
+
 	Hub string `envconfig:"HUB" default:"/ip4/192.168.1.3/tcp/10880/p2p/16Uiu2HAmP44YB5WWWdYccDYRzByum6fWDma13csdVUcySzwPMqYx"`
 
+	// the peer ID of Hub, the only caller trusted with authenticated clients
+	hubID peer.ID
+
+	// streams the saved events to the hub
+	live *bridge.LiveService
+
 	WebPort string `envconfig:"WEB_PORT" default:"4000"`
 
 	P2PPort string `envconfig:"P2P_PORT" default:"10880"`
@@ -55,11 +86,39 @@ type Relay struct {
 
 	TraceExporter string `envconfig:"TRACE_EXPORTER" default:"jaeger"`
 
+	MetricExporter string `envconfig:"METRIC_EXPORTER" default:"prometheus"`
+
+	LogExporter string `envconfig:"LOG_EXPORTER" default:""`
+
+	// TraceSampleRatio is the share of the websocket connections traced, the
+	// spans of their messages follow.
+	TraceSampleRatio float64 `envconfig:"TRACE_SAMPLE_RATIO" default:"1"`
+
+	TraceParentBased bool `envconfig:"TRACE_PARENT_BASED" default:"true"`
+
 	ServiceName string `envconfig:"SERVICE_NAME" default:""`
 
 	ElasticsearchURL string `envconfig:"ES_URL" default:""`
 
 	ElasticsearchIndex string `envconfig:"ES_INDEX" default:"events"`
+
+	// PrivateKindList are the kinds only served to their author and to the
+	// pubkeys they tag, once the hub authenticated them.
+	PrivateKindList []int `envconfig:"PRIVATE_KINDS" default:"4"`
+
+	// PolicyFile is a JSON file with the rules events must follow, see
+	// policy.Config. Only the size of events is limited without it.
+	PolicyFile string `envconfig:"POLICY_FILE" default:""`
+
+	policy *policy.Policy
+
+	// serves the Prometheus metrics at /metrics, nil with another
+	// MetricExporter
+	metrics http.Handler
+
+	// Limits are read from RATE_LIMIT_EVENTS_PER_IP and the like, see
+	// relayer.RateLimits.
+	Limits relayer.RateLimits `envconfig:"RATE_LIMIT"`
 }
 
 func (r *Relay) Name() string {
@@ -74,7 +133,61 @@ func (r *Relay) Storage() relayer.Storage {
 	return r.storage
 }
 
-func (r *Relay) OnInitialized(*relayer.Server) {}
+func (r *Relay) OnInitialized(s *relayer.Server) {
+	if r.metrics != nil {
+		s.Router().Path("/metrics").Handler(r.metrics)
+	}
+}
+
+func (r *Relay) PrivateKinds() []int {
+	return r.PrivateKindList
+}
+
+// BroadcastEvent streams the events written to the peer's own websocket to
+// the hub, like the ones the hub saves.
+func (r *Relay) BroadcastEvent(evt nostr.Event) {
+	if r.live != nil {
+		r.live.Publish(evt)
+	}
+}
+
+// TrustsHub reports whether id is the hub this peer registers with.
+func (r *Relay) TrustsHub(id peer.ID) bool {
+	return r.hubID != "" && id == r.hubID
+}
+
+// Hosts reports whether the peer stores the events of pubkey.
+func (r *Relay) Hosts(pubkey string) bool {
+	_, ok := r.hosted[pubkey]
+	return ok
+}
+
+func (r *Relay) loadConsents() error {
+	r.hosted = map[string]struct{}{r.BtcPubKey: {}}
+	if r.HostedConsents == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(r.HostedConsents)
+	if err != nil {
+		return err
+	}
+	var consents []nostr.Event
+	if err := json.Unmarshal(b, &consents); err != nil {
+		return err
+	}
+	for _, c := range consents {
+		pubKey, err := ql.VerifyConsent(&c, r.BtcPubKey)
+		if err != nil {
+			log.Printf("skipping consent %s: %v", c.ID, err)
+			continue
+		}
+		r.consents = append(r.consents, c)
+		r.hosted[pubKey] = struct{}{}
+	}
+	log.Printf("hosting %d pubkeys", len(r.hosted))
+	return nil
+}
 
 func (r *Relay) Init() error {
 	err := envconfig.Process("", r)
@@ -82,25 +195,57 @@ func (r *Relay) Init() error {
 		return fmt.Errorf("couldn't process envconfig: %w", err)
 	}
 
+	r.policy, err = policy.Load(r.PolicyFile)
+	if err != nil {
+		return fmt.Errorf("couldn't load the policy: %w", err)
+	}
+
+	hub, err := peer.AddrInfoFromString(r.Hub)
+	if err != nil {
+		return fmt.Errorf("couldn't read the peer ID of the hub: %w", err)
+	}
+	r.hubID = hub.ID
+
 	// every hour, delete all very old events
-	if r.ElasticsearchURL == "" {
+	switch db := r.Storage().(type) {
+	case *postgresql.PostgresBackend:
 		go func() {
-			db := r.Storage().(*postgresql.PostgresBackend)
-
 			for {
 				time.Sleep(60 * time.Minute)
 				db.DB.Exec(`DELETE FROM event WHERE created_at < $1`, time.Now().AddDate(0, -3, 0).Unix()) // 3 months
 			}
 		}()
+	case *sqlite.SQLiteBackend:
+		go func() {
+			for {
+				time.Sleep(60 * time.Minute)
+				db.DB.Exec(`DELETE FROM event WHERE created_at < ?`, time.Now().AddDate(0, -3, 0).Unix()) // 3 months
+			}
+		}()
 	}
 
 	go func() {
 		ticker := time.NewTicker(3 * time.Second)
 		logger := relayer.DefaultLogger()
+		client := ql.NewClient(r.host)
 		for range ticker.C {
-			reply, err := ql.QlCall(r.host, context.Background(), fmt.Sprintf("%s;%s", r.BtcPubKey, r.PeerAddress), r.Hub, "PingService", "Ping", "", nil)
+			reg, err := ql.NewRegistration(r.BtcPubKey, r.PeerAddress)
+			if err != nil {
+				logger.Panicf("failed to create registration: %v", err)
+			}
+			reg.Consents = r.consents
+			if err := reg.Sign(r.btcPvtKey); err != nil {
+				logger.Panicf("failed to sign registration: %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+			reply, err := client.Call(ctx, reg, r.Hub, "PingService", "Ping", "", nil)
+			cancel()
 			if err != nil {
-				if strings.Contains(fmt.Sprint(err), "connection refused") {
+				if strings.Contains(fmt.Sprint(err), "registration rejected") {
+					logger.Errorf("hub rejected the registration: %v", err)
+					ticker.Reset(15 * time.Second)
+					continue
+				} else if strings.Contains(fmt.Sprint(err), "connection refused") {
 					logger.Infof("connection refused, please check the address")
 					ticker.Reset(10 * time.Second)
 					continue
@@ -108,6 +253,10 @@ func (r *Relay) Init() error {
 					ticker.Reset(15 * time.Second)
 					logger.Errorf("dial backoff: %v", err)
 					continue
+				} else if ql.IsUnreachable(err) {
+					logger.Errorf("hub unreachable: %v", err)
+					ticker.Reset(10 * time.Second)
+					continue
 				} else {
 					logger.Panicf("error: %v", err)
 				}
@@ -120,32 +269,59 @@ func (r *Relay) Init() error {
 	return nil
 }
 
+// AcceptEvent accepts every event, the policy is enforced by RejectEvent.
 func (r *Relay) AcceptEvent(evt *nostr.Event) bool {
-	// block events that are too large
-	jsonb, _ := json.Marshal(evt)
-	if len(jsonb) > 10000 {
-		return false
-	}
-
 	return true
 }
 
+func (r *Relay) RateLimits() relayer.RateLimits {
+	return r.Limits
+}
+
+// RejectEvent returns why the policy rejects evt, if it does.
+func (r *Relay) RejectEvent(evt *nostr.Event) error {
+	return r.policy.Check(evt)
+}
+
 func main() {
 	r := Relay{}
 	if err := envconfig.Process("", &r); err != nil {
 		log.Fatalf("failed to read from env: %v", err)
 	}
-	tc, shutdown := trace.CreateTracers(trace.TracerConfig{
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if r.ElasticsearchURL != "" || r.SQLitePath != "" {
+			log.Fatalf("migrate: only the postgres storage has migrations")
+		}
+		db := &postgresql.PostgresBackend{DatabaseURL: r.PostgresDatabase, ServiceName: r.Name()}
+		if err := db.MigrateCommand(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+	telemetry, shutdown, err := trace.Setup(trace.TracerConfig{
 		ServiceName:    r.Name(),
 		Environment:    r.Environment,
 		ServiceVersion: r.Version,
 		TraceExporter:  r.TraceExporter,
+		MetricExporter: r.MetricExporter,
+		LogExporter:    r.LogExporter,
+		SampleRatio:    r.TraceSampleRatio,
+		ParentBased:    r.TraceParentBased,
 	})
-	defer shutdown(context.Background())
+	if err != nil {
+		log.Fatalf("failed to set up telemetry: %v", err)
+	}
+	if telemetry.LogHook != nil {
+		relayer.AddLogHook(telemetry.LogHook)
+	}
+	tc := telemetry.Tracer
+	r.metrics = telemetry.Metrics
 	if r.ElasticsearchURL != "" {
 		r.storage = &elasticsearch.ElasticsearchStorage{
 			IndexName: r.ElasticsearchIndex,
 		}
+	} else if r.SQLitePath != "" {
+		r.storage = &sqlite.SQLiteBackend{Path: r.SQLitePath, ServiceName: r.Name()}
 	} else {
 		r.storage = &postgresql.PostgresBackend{DatabaseURL: r.PostgresDatabase, ServiceName: r.Name()}
 	}
@@ -160,6 +336,10 @@ func main() {
 		log.Fatalf("failed to get priv key for libp2p: %v", err)
 	}
 	r.BtcPubKey = hex.EncodeToString(schnorr.SerializePubKey(btcPubKey))
+	r.btcPvtKey = btcPvtKey
+	if err := r.loadConsents(); err != nil {
+		log.Fatalf("failed to load hosted consents: %v", err)
+	}
 	log.Printf("BTC PvtKey: %s", hex.EncodeToString(btcPvtKey.Serialize()))
 	add := p2pHost.GetAdd(p, r.LocalNet)
 	h, err := p2pHost.GetHost(*privKey, add)
@@ -170,17 +350,40 @@ func main() {
 	peerAddr := p2pHost.GetMultiAddr(h)
 	r.PeerAddress = peerAddr.String()
 	log.Printf("Peer: listening on %s\n", peerAddr)
-	rpcHost := gorpc.NewServer(h, "/p2p/1.0.0")
-	bridgeService := bridge.NewBridgeService(&r, tc)
+	rpcHost := gorpc.NewServer(h, ql.ProtocolV1)
+	liveService := bridge.NewLiveService(&r)
+	r.live = liveService
+	bridgeService := bridge.NewBridgeService(&r, tc, liveService)
 	if err := rpcHost.Register(bridgeService); err != nil {
 		log.Fatalf("failed to register rpc server: %v", err)
 	}
+	qlHost := gorpc.NewServer(h, ql.ProtocolV2)
+	if err := qlHost.Register(bridge.NewQlService(bridgeService)); err != nil {
+		log.Fatalf("failed to register ql v2 rpc server: %v", err)
+	}
+	liveHost := gorpc.NewServer(h, ql.LiveProtocol)
+	if err := liveHost.Register(liveService); err != nil {
+		log.Fatalf("failed to register live rpc server: %v", err)
+	}
 	ecdsaPvtKey, _, _, _, err := keys.GetKeys(r.HubHex)
 	if err != nil {
 		log.Fatalf("failed to get priv key for hub hex: %v", err)
 	}
 	go handler.Start(fmt.Sprintf(":%s", r.WebPort), &r, &ecdsaPvtKey.PublicKey)
-	if err := relayer.Start(&r, nil, nil, nil, tc); err != nil {
+	var rs relayer.Settings
+	if err := envconfig.Process("", &rs); err != nil {
+		log.Fatalf("failed to read the relay settings from env: %v", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = relayer.StartConfContext(ctx, rs, &r, nil, nil, nil, nil, tc)
+	// the telemetry goes last, so that the shutdown is in it
+	sctx, cancel := context.WithTimeout(context.Background(), rs.ShutdownTimeout)
+	defer cancel()
+	if serr := shutdown(sctx); serr != nil {
+		log.Printf("failed to shut telemetry down: %v", serr)
+	}
+	if err != nil {
 		log.Fatalf("server terminated: %v", err)
 	}
 }
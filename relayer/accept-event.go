@@ -0,0 +1,21 @@
+package relayer
+
+import (
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/ql"
+)
+
+// CheckEvent returns why relay doesn't accept evt, see [Rejecter] and
+// [Relay.AcceptEvent], or nil when it does. The error message is ready to be
+// sent in an OK.
+func CheckEvent(relay Relay, evt *nostr.Event) error {
+	if rejecter, ok := relay.(Rejecter); ok {
+		if err := rejecter.RejectEvent(evt); err != nil {
+			return err
+		}
+	}
+	if !relay.AcceptEvent(evt) {
+		return ql.NewError(ql.CodeBlocked, "event blocked by relay")
+	}
+	return nil
+}
@@ -1,18 +1,26 @@
 package relayer
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/sithumonline/demedia-nostr/relayer/storage"
 )
 
+// AddEvent stores an event published to a relay without a host, see AddEventCtx.
 func AddEvent(relay Relay, evt nostr.Event) (accepted bool, message string) {
+	return AddEventCtx(context.Background(), relay, evt)
+}
+
+// AddEventCtx is AddEvent with a ctx bounding the storage call when it is a
+// [StorageCtx].
+func AddEventCtx(ctx context.Context, relay Relay, evt nostr.Event) (accepted bool, message string) {
 	store := relay.Storage()
 	advancedSaver, _ := store.(AdvancedSaver)
 
-	if !relay.AcceptEvent(&evt) {
-		return false, "blocked: event blocked by relay"
+	if err := CheckEvent(relay, &evt); err != nil {
+		return false, err.Error()
 	}
 
 	if 20000 <= evt.Kind && evt.Kind < 30000 {
@@ -22,9 +30,9 @@ func AddEvent(relay Relay, evt nostr.Event) (accepted bool, message string) {
 			advancedSaver.BeforeSave(&evt)
 		}
 
-		if saveErr := store.SaveEvent(&evt); saveErr != nil {
+		if saveErr := StorageWithContext(store).SaveEventCtx(ctx, &evt); saveErr != nil {
 			switch saveErr {
-			case storage.ErrDupEvent:
+			case storage.ErrDupEvent, storage.ErrOlderEvent:
 				return true, saveErr.Error()
 			default:
 				return false, fmt.Sprintf("error: failed to save: %s", saveErr.Error())
@@ -36,6 +44,9 @@ func AddEvent(relay Relay, evt nostr.Event) (accepted bool, message string) {
 		}
 	}
 
+	if b, ok := relay.(Broadcaster); ok {
+		b.BroadcastEvent(evt)
+	}
 	notifyListeners(&evt)
 
 	return true, ""
@@ -0,0 +1,81 @@
+package relayer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/ql"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CountPeers counts the events matching filter on the peers, see NIP-45. A
+// single replica is asked for each author so that replicated events are
+// counted once. A filter scattered to every peer counts each copy, so its sum
+// is divided by the replication factor and reported approximate, as is the
+// count when some peers failed. An error is only returned when none of them
+// answered.
+func CountPeers(ctx context.Context, filter *nostr.Filter, relay Relay, client *ql.Client, span trace.Span) (count int64, approximate bool, err error) {
+	plan := planQuery(relay, filter, 1)
+	if len(plan) == 0 {
+		return 0, false, fmt.Errorf("error: failed to count: no peer available")
+	}
+	ctx, cancel := peerContext(ctx)
+	defer cancel()
+
+	type result struct {
+		address string
+		count   int64
+		err     error
+	}
+	results := make(chan result, len(plan))
+	for address, f := range plan {
+		go func(address string, f *nostr.Filter) {
+			count, err := client.CountEvents(ctx, address, f, span)
+			results <- result{address, count, err}
+		}(address, f)
+	}
+
+	log := DefaultLogger()
+	answered := 0
+	for range plan {
+		res := <-results
+		if res.err != nil {
+			log.WarningfWithContext(ctx, "peer %s failed to count: %v", res.address, res.err)
+			err = res.err
+			continue
+		}
+		answered++
+		count += res.count
+	}
+	if answered == 0 {
+		return 0, false, fmt.Errorf("error: failed to count: %s", err.Error())
+	}
+	approximate = answered < len(plan)
+
+	if copies := scatteredCopies(relay, filter, len(plan)); copies > 1 {
+		count = (count + int64(copies)/2) / int64(copies)
+		approximate = true
+	}
+	return count, approximate, nil
+}
+
+// scatteredCopies returns how many of the peers a filter is scattered to hold
+// a copy of each event, 1 when it isn't scattered or not replicated.
+func scatteredCopies(relay Relay, filter *nostr.Filter, peers int) int {
+	if len(filter.Authors) > 0 || len(filter.Tags["p"]) > 0 {
+		return 1
+	}
+	rep, ok := relay.(Replicator)
+	if _, isLister := relay.Storage().(PeerLister); !ok || !isLister {
+		return 1
+	}
+	n := rep.ReplicationFactor()
+	if n > peers {
+		n = peers
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
@@ -0,0 +1,78 @@
+package relayer
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/storage/memory"
+)
+
+func TestCount(t *testing.T) {
+	store := &memory.MemoryBackend{}
+	srv := startTestRelay(t, &testRelay{storage: store})
+	defer srv.Shutdown(context.Background())
+
+	for i, kind := range []int{1, 1, 7} {
+		evt := nostr.Event{ID: string(rune('a' + i)), PubKey: "alice", Kind: kind, CreatedAt: time.Unix(int64(i), 0)}
+		if err := store.SaveEvent(&evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+srv.Addr(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteJSON([]interface{}{"COUNT", "c", nostr.Filter{Kinds: []int{1}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var reply []json.RawMessage
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatal(err)
+	}
+	var typ, id string
+	var result struct{ Count int64 }
+	if len(reply) == 3 {
+		json.Unmarshal(reply[0], &typ)
+		json.Unmarshal(reply[1], &id)
+		json.Unmarshal(reply[2], &result)
+	}
+	if typ != "COUNT" || id != "c" || result.Count != 2 {
+		t.Errorf("got %s, want [\"COUNT\",\"c\",{\"count\":2}]", reply)
+	}
+}
+
+func TestCountRejectsSeveralFilters(t *testing.T) {
+	srv := startTestRelay(t, &testRelay{storage: &memory.MemoryBackend{}})
+	defer srv.Shutdown(context.Background())
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+srv.Addr(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// an event of kind 1 by alice would be counted twice
+	if err := conn.WriteJSON([]interface{}{"COUNT", "c", nostr.Filter{Kinds: []int{1}}, nostr.Filter{Authors: []string{"alice"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var reply []json.RawMessage
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatal(err)
+	}
+	var typ string
+	if len(reply) > 0 {
+		json.Unmarshal(reply[0], &typ)
+	}
+	if typ != "NOTICE" {
+		t.Errorf("got %s, want a NOTICE", reply)
+	}
+}
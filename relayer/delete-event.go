@@ -4,17 +4,29 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/sithumonline/demedia-nostr/relayer/ql"
 	"go.opentelemetry.io/otel/trace"
 )
 
-func DeleteEvent(relay Relay, evt nostr.Event, host host.Host, ctx context.Context, span trace.Span) error {
-	store := relay.Storage()
-	address := store.GetPeer(evt.PubKey)
-	_, sandErr := ql.QlCall(host, ctx, evt, address, "BridgeService", "Ql", "deleteEvent", span)
-	if sandErr != nil {
+func DeleteEvent(relay Relay, evt nostr.Event, client *ql.Client, ctx context.Context, span trace.Span) error {
+	// deletions go to every replica so they don't come back on merged reads
+	addresses, _ := replicas(relay, evt.PubKey)
+	ctx, cancel := peerContext(ctx)
+	defer cancel()
+	deleted := 0
+	var sandErr error
+	for _, address := range addresses {
+		if err := client.DeleteEvent(ctx, address, evt.ID, evt.PubKey, span); err != nil {
+			sandErr = err
+			continue
+		}
+		deleted++
+	}
+	if deleted == 0 {
+		if sandErr == nil {
+			return fmt.Errorf("error: failed to delete: no peer for %s", evt.PubKey)
+		}
 		return fmt.Errorf("error: failed to delete: %s", sandErr.Error())
 	}
 	return nil
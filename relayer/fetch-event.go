@@ -2,27 +2,183 @@ package relayer
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"time"
 
-	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/sithumonline/demedia-nostr/relayer/ql"
 	"go.opentelemetry.io/otel/trace"
 )
 
-func FetchEvent(pubKey string, filter *nostr.Filter, relay Relay, host host.Host, ctx context.Context, span trace.Span) (events []nostr.Event, err error) {
-	address := relay.Storage().GetPeer(pubKey)
-	reply, sandErr := ql.QlCall(host, ctx, filter, address, "BridgeService", "Ql", "queryEvents", span)
-	if sandErr != nil {
-		return nil, fmt.Errorf("error: failed to fetch: %s", sandErr.Error())
+// StreamPeers answers filter from the peers, calling send for each event as it
+// arrives. A filter naming authors is split among the peers serving each author,
+// one with a "p" tag goes to the peers of its first value and any other is
+// scattered to every live peer.
+// Events are deduplicated across peers. Without filter.Limit they are sent
+// unsorted as they arrive; with it, the newest events may come from any peer,
+// so they are held until all peers answered and the newest filter.Limit are
+// sent by created_at DESC. Peers that fail or stop sending for longer than
+// the query timeout of [WithPeerTimeouts] are skipped, so results may be partial.
+func StreamPeers(ctx context.Context, filter *nostr.Filter, relay Relay, client *ql.Client, span trace.Span, send func(nostr.Event)) error {
+	plan := planQuery(relay, filter, 0)
+	if len(plan) == 0 {
+		return fmt.Errorf("error: failed to fetch: no peer available")
 	}
 
-	var d []nostr.Event
-	err = json.Unmarshal(reply.Data, &d)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal reply data: %v", err)
+	// ends with the connection, so that a closed one doesn't wait on its peers
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if span != nil {
+		sctx = trace.ContextWithSpan(sctx, span)
 	}
 
-	return d, nil
+	type result struct {
+		address string
+		evt     *nostr.Event
+		err     error
+	}
+	// unbuffered, so that peers only send as fast as send consumes
+	results := make(chan result)
+	for address, f := range plan {
+		go func(address string, f *nostr.Filter) {
+			err := streamFrom(address, f, client, sctx, span, func(evt nostr.Event) bool {
+				select {
+				case results <- result{address: address, evt: &evt}:
+					return true
+				case <-sctx.Done():
+					return false
+				}
+			})
+			select {
+			case results <- result{address: address, err: err}:
+			case <-sctx.Done():
+			}
+		}(address, f)
+	}
+
+	log := DefaultLogger()
+	queryTimeout := peerTimeoutsOf(ctx).query
+	idle := time.NewTimer(queryTimeout)
+	defer idle.Stop()
+	seen := make(map[string]struct{})
+	var limited []nostr.Event
+	answered := 0
+	var err error
+	for pending := len(plan); pending > 0; {
+		select {
+		case res := <-results:
+			if res.evt == nil {
+				pending--
+				if res.err != nil {
+					log.WarningfWithContext(ctx, "peer %s failed to answer: %v", res.address, res.err)
+					err = res.err
+				} else {
+					answered++
+				}
+				continue
+			}
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(queryTimeout)
+			if _, ok := seen[res.evt.ID]; ok {
+				continue
+			}
+			seen[res.evt.ID] = struct{}{}
+			if filter.Limit > 0 {
+				limited = append(limited, *res.evt)
+				continue
+			}
+			send(*res.evt)
+		case <-idle.C:
+			log.WarningfWithContext(ctx, "%d of %d peers timed out", pending, len(plan))
+			if err == nil {
+				err = fmt.Errorf("error: failed to fetch: peers timed out")
+			}
+			pending = 0
+		}
+	}
+	if filter.Limit > 0 {
+		limited = mergeEvents(limited)
+		if len(limited) > filter.Limit {
+			limited = limited[:filter.Limit]
+		}
+		for _, evt := range limited {
+			send(evt)
+		}
+	}
+	if answered == 0 && len(seen) == 0 {
+		return err
+	}
+	return nil
+}
+
+// streamFrom streams the events matching filter from the peer at address,
+// until the peer is done or send returns false.
+func streamFrom(address string, filter *nostr.Filter, client *ql.Client, ctx context.Context, span trace.Span, send func(nostr.Event) bool) error {
+	events := make(chan nostr.Event)
+	done := make(chan error, 1)
+	go func() {
+		done <- client.StreamEvents(ctx, address, filter, span, events)
+	}()
+
+	var err error
+	for evt := range events {
+		if err == nil && !send(evt) {
+			err = ctx.Err()
+		}
+	}
+	if serr := <-done; serr != nil {
+		return fmt.Errorf("error: failed to fetch: %s", serr.Error())
+	}
+	return err
+}
+
+// planQuery maps the address of each peer to query to the filter it should answer.
+// When copies is positive, only that many of the replicas holding an author's
+// events are asked, otherwise all of them are.
+func planQuery(relay Relay, filter *nostr.Filter, copies int) map[string]*nostr.Filter {
+	plan := make(map[string]*nostr.Filter)
+
+	if len(filter.Authors) > 0 {
+		for _, author := range filter.Authors {
+			addresses, _ := replicas(relay, author)
+			if copies > 0 && len(addresses) > copies {
+				addresses = addresses[:copies]
+			}
+			for _, address := range addresses {
+				f, ok := plan[address]
+				if !ok {
+					sub := *filter
+					sub.Authors = nil
+					f = &sub
+					plan[address] = f
+				}
+				f.Authors = append(f.Authors, author)
+			}
+		}
+		return plan
+	}
+
+	if receivers := filter.Tags["p"]; len(receivers) > 0 {
+		addresses, _ := replicas(relay, receivers[0])
+		if copies > 0 && len(addresses) > copies {
+			addresses = addresses[:copies]
+		}
+		for _, address := range addresses {
+			plan[address] = filter
+		}
+		return plan
+	}
+
+	var addresses []string
+	if lister, ok := relay.Storage().(PeerLister); ok {
+		addresses = lister.GetAllPeers()
+	} else if address := relay.Storage().GetPeer(""); address != "" {
+		addresses = []string{address}
+	}
+	for _, address := range addresses {
+		plan[address] = filter
+	}
+	return plan
 }
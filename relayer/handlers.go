@@ -1,6 +1,7 @@
 package relayer
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
@@ -18,32 +19,13 @@ import (
 	"github.com/nbd-wtf/go-nostr/nip11"
 	"github.com/nbd-wtf/go-nostr/nip42"
 	"github.com/sithumonline/demedia-nostr/relayer/hashutil"
+	"github.com/sithumonline/demedia-nostr/relayer/ql"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/exp/slices"
 )
 
-// TODO: consider moving these to Server as config params
-const (
-	// Time allowed to write a message to the peer.
-	writeWait = 10 * time.Second
-
-	// Time allowed to read the next pong message from the peer.
-	pongWait = 60 * time.Second
-
-	// Send pings to peer with this period. Must be less than pongWait.
-	pingPeriod = pongWait / 2
-
-	// Maximum message size allowed from peer.
-	maxMessageSize = 512000
-)
-
-// TODO: consider moving these to Server as config params
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin:     func(r *http.Request) bool { return true },
-}
-
 func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 	ctx, span := s.tracer.Start(r.Context(), "handleWebsocket")
 	span.SetAttributes(attribute.String("span_id", span.SpanContext().SpanID().String()))
@@ -53,7 +35,7 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 	advancedDeleter, _ := store.(AdvancedDeleter)
 	advancedQuerier, _ := store.(AdvancedQuerier)
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		s.Log.ErrorfWithContext(ctx, "failed to upgrade websocket: %v", err)
 		return
@@ -61,7 +43,8 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 	s.clientsMu.Lock()
 	defer s.clientsMu.Unlock()
 	s.clients[conn] = struct{}{}
-	ticker := time.NewTicker(pingPeriod)
+	// pings are sent twice before the client is considered gone
+	ticker := time.NewTicker(s.pongWait / 2)
 
 	// NIP-42 challenge
 	challenge := make([]byte, 8)
@@ -71,9 +54,18 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 		conn:      conn,
 		challenge: hex.EncodeToString(challenge),
 	}
+	if s.limiter != nil {
+		ws.ip = s.limiter.clientIP(r)
+		ws.budget = s.limiter.connection()
+	}
 	s.Log.InfofWithContext(ctx, "challenge: %s", ws.challenge)
 	// reader
 	go func() {
+		// the request ctx is done as soon as handleWebsocket returns, so the
+		// connection gets one of its own, cancelled once it is closed
+		ctx, cancel := context.WithCancel(trace.ContextWithSpan(context.Background(), span))
+		defer cancel()
+		ctx = withPeerTimeouts(ctx, s.peerTimeouts)
 		ctx, span := s.tracer.Start(ctx, "handleWebsocket.reader")
 		span.SetAttributes(attribute.String("span_id", span.SpanContext().SpanID().String()))
 		defer span.End()
@@ -88,10 +80,10 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 			s.clientsMu.Unlock()
 		}()
 
-		conn.SetReadLimit(maxMessageSize)
-		conn.SetReadDeadline(time.Now().Add(pongWait))
+		conn.SetReadLimit(s.maxMessageSize)
+		conn.SetReadDeadline(time.Now().Add(s.pongWait))
 		conn.SetPongHandler(func(string) error {
-			conn.SetReadDeadline(time.Now().Add(pongWait))
+			conn.SetReadDeadline(time.Now().Add(s.pongWait))
 			return nil
 		})
 
@@ -100,355 +92,483 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 			ws.WriteJSON([]interface{}{"AUTH", ws.challenge})
 		}
 		s.Log.InfofWithContext(ctx, "auth challenge sent")
-		for {
-			ctx, span := s.tracer.Start(ctx, "handleWebsocket.reader.for")
+
+		// handleMessage handles the messages of the connection one at a time, in
+		// the order they were read
+		handleMessage := func(ctx context.Context, message []byte) {
+			ctx, span := s.tracer.Start(ctx, "handleWebsocket.reader.message")
 			span.SetAttributes(attribute.String("span_id", span.SpanContext().SpanID().String()))
-			s.Log.InfofWithContext(ctx, "inside for loop and waiting for message")
-			typ, message, err := conn.ReadMessage()
-			if err != nil {
-				if websocket.IsUnexpectedCloseError(
-					err,
-					websocket.CloseGoingAway,        // 1001
-					websocket.CloseNoStatusReceived, // 1005
-					websocket.CloseAbnormalClosure,  // 1006
-				) {
-					s.Log.WarningfWithContext(ctx, "unexpected close error from %s: %v", r.Header.Get("X-Forwarded-For"), err)
+			defer span.End()
+			s.Log.InfofWithContext(ctx, "handling message")
+			var err error
+			// peers enforce their own rules on what the client may read
+			ctx = ql.WithAuthed(ctx, ws.authed)
+			var notice string
+			defer func() {
+				if notice != "" {
+					ws.WriteJSON([]interface{}{"NOTICE", notice})
 				}
-				break
-			}
+			}()
 
-			if typ == websocket.PingMessage {
-				ws.WriteMessage(websocket.PongMessage, nil)
-				continue
+			var request []json.RawMessage
+			if err := json.Unmarshal(message, &request); err != nil {
+				// stop silently
+				return
 			}
 
-			go func(message []byte) {
-				ctx, span := s.tracer.Start(ctx, "handleWebsocket.reader.for.go")
-				span.SetAttributes(attribute.String("span_id", span.SpanContext().SpanID().String()))
-				defer span.End()
-				s.Log.InfofWithContext(ctx, "initializing go routine for message")
-				var notice string
-				defer func() {
-					if notice != "" {
-						ws.WriteJSON([]interface{}{"NOTICE", notice})
-					}
-				}()
+			if len(request) < 2 {
+				notice = "request has less than 2 parameters"
+				return
+			}
 
-				var request []json.RawMessage
-				if err := json.Unmarshal(message, &request); err != nil {
-					// stop silently
-					return
-				}
+			var typ string
+			json.Unmarshal(request[0], &typ)
 
-				if len(request) < 2 {
-					notice = "request has less than 2 parameters"
+			switch typ {
+			case "EVENT":
+				// it's a new event
+				var evt nostr.Event
+				if err := json.Unmarshal(request[1], &evt); err != nil {
+					notice = "failed to decode event: " + err.Error()
 					return
 				}
 
-				var typ string
-				json.Unmarshal(request[0], &typ)
-
-				switch typ {
-				case "EVENT":
-					// it's a new event
-					var evt nostr.Event
-					if err := json.Unmarshal(request[1], &evt); err != nil {
-						notice = "failed to decode event: " + err.Error()
+				if s.limiter != nil {
+					if ok, message := s.limiter.allowEvent(ctx, ws); !ok {
+						s.replyOK(ctx, ws, evt.ID, false, message)
 						return
 					}
+				}
 
-					// check serialization
-					serialized := evt.Serialize()
+				// check serialization
+				serialized := evt.Serialize()
 
-					// assign ID
-					hash := sha256.Sum256(serialized)
-					evt.ID = hex.EncodeToString(hash[:])
+				// assign ID
+				hash := sha256.Sum256(serialized)
+				evt.ID = hex.EncodeToString(hash[:])
 
-					// check signature (requires the ID to be set)
-					if ok, err := evt.CheckSignature(); err != nil {
-						ws.WriteJSON([]interface{}{"OK", evt.ID, false, "error: failed to verify signature"})
-						return
-					} else if !ok && evt.Kind != 5 {
-						ws.WriteJSON([]interface{}{"OK", evt.ID, false, "invalid: signature is invalid"})
+				// check signature (requires the ID to be set)
+				signed, err := evt.CheckSignature()
+				if err != nil {
+					s.replyOK(ctx, ws, evt.ID, false, "error: failed to verify signature")
+					return
+				} else if !signed && evt.Kind != 5 {
+					s.replyOK(ctx, ws, evt.ID, false, "invalid: signature is invalid")
+					return
+				}
+
+				// an unsigned deletion may claim any pubkey, it must not
+				// spend the budget of that pubkey
+				if s.limiter != nil && signed {
+					if ok, message := s.limiter.allowPubKey(ctx, evt.PubKey); !ok {
+						s.replyOK(ctx, ws, evt.ID, false, message)
 						return
 					}
+				}
 
-					if evt.Kind == 5 {
-						// event deletion -- nip09
-						for _, tag := range evt.Tags {
-							if len(tag) >= 2 && tag[0] == "e" {
-								if advancedDeleter != nil {
-									advancedDeleter.BeforeDelete(tag[1], evt.PubKey)
-								}
+				if evt.Kind == 5 {
+					// event deletion -- nip09
+					for _, tag := range evt.Tags {
+						if len(tag) >= 2 && tag[0] == "e" {
+							if advancedDeleter != nil {
+								advancedDeleter.BeforeDelete(tag[1], evt.PubKey)
+							}
 
-								s.Log.InfofWithContext(ctx, "delete event id: %s, key: %s", tag[1], evt.PubKey)
-								if s.host != nil {
-									if err := DeleteEvent(s.relay, nostr.Event{ID: tag[1], PubKey: evt.PubKey}, s.host, ctx, span); err != nil {
-										ws.WriteJSON([]interface{}{"OK", evt.ID, false, fmt.Sprintf("error: %s", err.Error())})
-										return
-									}
-								} else {
-									if err := store.DeleteEvent(tag[1], evt.PubKey); err != nil {
-										ws.WriteJSON([]interface{}{"OK", evt.ID, false, fmt.Sprintf("error: %s", err.Error())})
-										return
-									}
+							s.Log.InfofWithContext(ctx, "delete event id: %s, key: %s", tag[1], evt.PubKey)
+							if s.host != nil {
+								if err := DeleteEvent(s.relay, nostr.Event{ID: tag[1], PubKey: evt.PubKey}, s.client, ctx, span); err != nil {
+									s.replyOK(ctx, ws, evt.ID, false, fmt.Sprintf("error: %s", err.Error()))
+									return
 								}
-
-								if advancedDeleter != nil {
-									advancedDeleter.AfterDelete(tag[1], evt.PubKey)
+							} else {
+								if err := StorageWithContext(store).DeleteEventCtx(ctx, tag[1], evt.PubKey); err != nil {
+									s.replyOK(ctx, ws, evt.ID, false, fmt.Sprintf("error: %s", err.Error()))
+									return
 								}
 							}
-							if tag[0] == "audio" && s.ipfs != nil {
-								urlChunks := strings.Split(tag[1], "/")
-								if len(urlChunks) == 5 {
-									s.Log.InfofWithContext(ctx, "delete cid: %s", urlChunks[4])
-									if err = s.ipfs.DeleteFile(urlChunks[4]); err != nil {
-										ws.WriteJSON([]interface{}{"OK", evt.ID, false, fmt.Sprintf("error: %s", err.Error())})
-										return
-									}
+
+							if advancedDeleter != nil {
+								advancedDeleter.AfterDelete(tag[1], evt.PubKey)
+							}
+						}
+						if tag[0] == "audio" && s.ipfs != nil {
+							urlChunks := strings.Split(tag[1], "/")
+							if len(urlChunks) == 5 {
+								s.Log.InfofWithContext(ctx, "delete cid: %s", urlChunks[4])
+								if err = s.ipfs.DeleteFile(urlChunks[4]); err != nil {
+									s.replyOK(ctx, ws, evt.ID, false, fmt.Sprintf("error: %s", err.Error()))
+									return
 								}
 							}
 						}
-						return
 					}
+					return
+				}
 
-					isEvtChanged := false
-					if evt.Kind == 1 && (s.blob != nil || s.ipfs != nil) {
-						for _, tag := range evt.Tags {
-							if len(tag) != 2 {
-								continue
-							}
-							if tag[0] != "audio" {
-								continue
-							}
+				isEvtChanged := false
+				if evt.Kind == 1 && (s.blob != nil || s.ipfs != nil) {
+					for _, tag := range evt.Tags {
+						if len(tag) != 2 {
+							continue
+						}
+						if tag[0] != "audio" {
+							continue
+						}
+
+						s.Log.InfofWithContext(ctx, "media tag: %s url: %s", tag[0], tag[1])
 
-							s.Log.InfofWithContext(ctx, "media tag: %s url: %s", tag[0], tag[1])
+						fileBytes, err := s.fetchMedia(ctx, tag[1])
+						if err != nil {
+							s.Log.ErrorfWithContext(ctx, "failed to get file from url: %v", err)
+							continue
+						}
 
-							reqClient := req.C()        // Use C() to create a client.
-							resp, err := reqClient.R(). // Use R() to create a request.
-											Get(tag[1])
+						var u = ""
+						if s.blob != nil {
+							fileSplit := strings.Split(tag[1], "/")
+							fileName := fmt.Sprintf("hub_%s", fileSplit[len(fileSplit)-1])
+							err = s.blob.SaveFile(fileName, fileBytes)
 							if err != nil {
-								s.Log.ErrorfWithContext(ctx, "failed to get file from url: %v", err)
+								s.Log.ErrorfWithContext(ctx, "failed to h save file to blob: %v", err)
 								continue
 							}
-							defer resp.Body.Close()
 
-							fileBytes, err := io.ReadAll(resp.Body)
+							u, err = s.blob.GetFileURL(fileName)
 							if err != nil {
-								s.Log.ErrorfWithContext(ctx, "failed to h io read: %v", err)
+								s.Log.ErrorfWithContext(ctx, "failed to h get url: %v", err)
 								continue
 							}
-
-							var u = ""
-							if s.blob != nil {
-								fileSplit := strings.Split(tag[1], "/")
-								fileName := fmt.Sprintf("hub_%s", fileSplit[len(fileSplit)-1])
-								err = s.blob.SaveFile(fileName, fileBytes)
-								if err != nil {
-									s.Log.ErrorfWithContext(ctx, "failed to h save file to blob: %v", err)
-									continue
-								}
-
-								u, err = s.blob.GetFileURL(fileName)
-								if err != nil {
-									s.Log.ErrorfWithContext(ctx, "failed to h get url: %v", err)
-									continue
-								}
-							} else if s.ipfs != nil {
-								u, err = s.ipfs.UploadFile(fileBytes)
-								if err != nil {
-									s.Log.ErrorfWithContext(ctx, "failed to h save file to ipfs: %v", err)
-									continue
-								}
-								s.Log.InfofWithContext(ctx, "ipfs file saved url: %s", u)
+						} else if s.ipfs != nil {
+							u, err = s.ipfs.UploadFile(fileBytes)
+							if err != nil {
+								s.Log.ErrorfWithContext(ctx, "failed to h save file to ipfs: %v", err)
+								continue
 							}
-
-							tag[1] = u
-							isEvtChanged = true
-							s.Log.InfofWithContext(ctx, "audio url changed to: %s", u)
+							s.Log.InfofWithContext(ctx, "ipfs file saved url: %s", u)
 						}
+
+						tag[1] = u
+						isEvtChanged = true
+						s.Log.InfofWithContext(ctx, "audio url changed to: %s", u)
 					}
+				}
 
-					for _, tag := range evt.Tags {
-						if len(tag) >= 2 {
-							if tag[0] == "p" || tag[0] == "e" {
-								isEvtChanged = true
-							}
+				for _, tag := range evt.Tags {
+					if len(tag) >= 2 {
+						if tag[0] == "p" || tag[0] == "e" {
+							isEvtChanged = true
 						}
 					}
-					s.Log.InfofWithContext(ctx, fmt.Sprintf("before sig content evt.kind: %d isEcdsaPvtKey: %t isEvtChanged: %t len.evt.Tags: %d", evt.Kind, s.ecdsaPvtKey != nil, isEvtChanged, len(evt.Tags)))
-					isHashAdded := false
-					if evt.Kind == 1 && s.ecdsaPvtKey != nil && (isEvtChanged || len(evt.Tags) == 0) {
-						sig, err := hashutil.GetSing(hashutil.GetSha256([]byte(evt.Content)), s.ecdsaPvtKey)
-						if err != nil {
-							s.Log.ErrorfWithContext(ctx, "failed to calculate sig: %v", err)
-						} else {
-							evt.Tags = evt.Tags.AppendUnique([]string{"hash", sig, "true"})
-							isHashAdded = true
-						}
+				}
+				s.Log.InfofWithContext(ctx, fmt.Sprintf("before sig content evt.kind: %d isEcdsaPvtKey: %t isEvtChanged: %t len.evt.Tags: %d", evt.Kind, s.ecdsaPvtKey != nil, isEvtChanged, len(evt.Tags)))
+				isHashAdded := false
+				if evt.Kind == 1 && s.ecdsaPvtKey != nil && (isEvtChanged || len(evt.Tags) == 0) {
+					sig, err := hashutil.GetSing(hashutil.GetSha256([]byte(evt.Content)), s.ecdsaPvtKey)
+					if err != nil {
+						s.Log.ErrorfWithContext(ctx, "failed to calculate sig: %v", err)
+					} else {
+						evt.Tags = evt.Tags.AppendUnique([]string{"hash", sig, "true"})
+						isHashAdded = true
 					}
+				}
 
-					p := ""
-					if isEvtChanged || isHashAdded {
-						p = hashutil.StringifyEvent(&evt)
-					}
+				p := ""
+				if isEvtChanged || isHashAdded {
+					p = hashutil.StringifyEvent(&evt)
+				}
+
+				if p != "" {
+					// gen hash for event as audio url changed
+					bs := hashutil.GetSha256([]byte(p))
+					evt.ID = fmt.Sprintf("%x", bs)
+				}
+
+				if s.host != nil {
+					s.Log.InfofWithContext(ctx, "initializing send event to peer")
+					ok, message := SendEvent(s.relay, evt, s.client, ctx, span)
+					s.Log.InfofWithContext(ctx, "completed send event to peer")
+					s.replyOK(ctx, ws, evt.ID, ok, message)
+				} else {
+					ok, message := AddEventCtx(ctx, s.relay, evt)
+					s.replyOK(ctx, ws, evt.ID, ok, message)
+				}
+
+			case "REQ":
+				var id string
+				json.Unmarshal(request[1], &id)
+				if id == "" {
+					notice = "REQ has no <id>"
+					return
+				}
 
-					if p != "" {
-						// gen hash for event as audio url changed
-						bs := hashutil.GetSha256([]byte(p))
-						evt.ID = fmt.Sprintf("%x", bs)
+				if s.limiter != nil {
+					if ok, message := s.limiter.allowReq(ctx, ws); !ok {
+						notice = message
+						return
+					}
+					if ok, message := s.limiter.allowSubscription(ctx, ws, id); !ok {
+						notice = message
+						return
 					}
+				}
 
-					if s.host != nil {
-						s.Log.InfofWithContext(ctx, "initializing send event to peer")
-						ok, message := SendEvent(s.relay, evt, s.host, ctx, span)
-						s.Log.InfofWithContext(ctx, "completed send event to peer")
-						ws.WriteJSON([]interface{}{"OK", evt.ID, ok, message})
-					} else {
-						ok, message := AddEvent(s.relay, evt)
-						ws.WriteJSON([]interface{}{"OK", evt.ID, ok, message})
+				filters := make(nostr.Filters, len(request)-2)
+				// search filters are answered once, they aren't listened to
+				live := make(nostr.Filters, 0, len(filters))
+				for i, filterReq := range request[2:] {
+					if err := json.Unmarshal(
+						filterReq,
+						&filters[i],
+					); err != nil {
+						notice = "failed to decode filter"
+						return
 					}
 
-				case "REQ":
-					var id string
-					json.Unmarshal(request[1], &id)
-					if id == "" {
-						notice = "REQ has no <id>"
+					filter := &filters[i]
+
+					if notice = s.restrictFilter(ws, filter); notice != "" {
+						// do not return any events, even if other elements in
+						//   filters array were not restricted. client should know better.
 						return
 					}
 
-					filters := make(nostr.Filters, len(request)-2)
-					for i, filterReq := range request[2:] {
-						if err := json.Unmarshal(
-							filterReq,
-							&filters[i],
-						); err != nil {
-							notice = "failed to decode filter"
-							return
-						}
+					// go-nostr doesn't know about NIP-50 yet
+					var ext struct {
+						Search string `json:"search"`
+					}
+					json.Unmarshal(filterReq, &ext)
+					if ext.Search == "" {
+						live = append(live, filters[i])
+					}
 
-						filter := &filters[i]
-
-						// prevent kind-4 events from being returned to unauthed users,
-						//   only when authentication is a thing
-						if _, ok := s.relay.(Auther); ok {
-							if slices.Contains(filter.Kinds, 4) {
-								senders := filter.Authors
-								receivers, _ := filter.Tags["p"]
-								switch {
-								case ws.authed == "":
-									// not authenticated
-									notice = "restricted: this relay does not serve kind-4 to unauthenticated users, does your client implement NIP-42?"
-									return
-								case len(senders) == 1 && len(receivers) < 2 && (senders[0] == ws.authed):
-									// allowed filter: ws.authed is sole sender (filter specifies one or all receivers)
-								case len(receivers) == 1 && len(senders) < 2 && (receivers[0] == ws.authed):
-									// allowed filter: ws.authed is sole receiver (filter specifies one or all senders)
-								default:
-									// restricted filter: do not return any events,
-									//   even if other elements in filters array were not restricted).
-									//   client should know better.
-									notice = "restricted: authenticated user does not have authorization for requested filters."
-									return
+					if advancedQuerier != nil {
+						advancedQuerier.BeforeQuery(filter)
+					}
+
+					sendEvent := func(event nostr.Event) {
+						if event.Kind == 1 && s.ecdsaPvtKey != nil && len(event.Tags) > 0 {
+							for _, tag := range event.Tags {
+								if len(tag) >= 2 {
+									if tag[0] == "hash" {
+										b, err := hashutil.GetVerification(tag[1], hashutil.GetSha256([]byte(event.Content)), &s.ecdsaPvtKey.PublicKey)
+										if err != nil {
+											s.Log.ErrorfWithContext(ctx, "failed to verify sig: %v", err)
+										} else {
+											tag[2] = strconv.FormatBool(b)
+											bs := hashutil.GetSha256([]byte(hashutil.StringifyEvent(&event)))
+											event.ID = fmt.Sprintf("%x", bs)
+										}
+									}
 								}
 							}
 						}
 
-						if advancedQuerier != nil {
-							advancedQuerier.BeforeQuery(filter)
-						}
+						s.Log.InfofWithContext(ctx, "sending EVENT ID: %s", id)
+						ws.WriteJSON([]interface{}{"EVENT", id, event})
+					}
 
-						var events []nostr.Event
+					var events []nostr.Event
+					if ext.Search != "" {
+						var results []storage.SearchResult
 						if s.host != nil {
-							senders := filter.Authors
-							receivers, _ := filter.Tags["p"]
-							var pubKey string
-							if len(senders) != 0 {
-								pubKey = senders[0]
-							} else if len(receivers) != 0 {
-								pubKey = receivers[0]
-							}
-							s.Log.InfofWithContext(ctx, "fetching events from peer ID: %s", id)
-							events, err = FetchEvent(pubKey, filter, s.relay, s.host, ctx, span)
-							s.Log.InfofWithContext(ctx, "completed fetching events from peer ID: %s", id)
+							s.Log.InfofWithContext(ctx, "searching events on peers ID: %s", id)
+							results, err = SearchPeers(ctx, filter, ext.Search, s.relay, s.client, span)
+						} else if searcher, ok := store.(Searcher); ok {
+							results, err = searcher.SearchEvents(ctx, filter, ext.Search)
 						} else {
-							events, err = store.QueryEvents(filter)
+							notice = "error: this relay does not support search"
+							continue
+						}
+						if err != nil {
+							s.Log.ErrorfWithContext(ctx, "search: %v", err)
+							continue
+						}
+						for _, result := range results {
+							events = append(events, result.Event)
+							sendEvent(result.Event)
+						}
+						if advancedQuerier != nil {
+							advancedQuerier.AfterQuery(events, filter)
 						}
+						continue
+					}
 
+					if s.host != nil {
+						// events are forwarded as the peers stream them
+						s.Log.InfofWithContext(ctx, "fetching events from peers ID: %s", id)
+						err = StreamPeers(ctx, filter, s.relay, s.client, span, func(event nostr.Event) {
+							if advancedQuerier != nil {
+								events = append(events, event)
+							}
+							sendEvent(event)
+						})
+						s.Log.InfofWithContext(ctx, "completed fetching events from peers ID: %s", id)
 						if err != nil {
 							s.Log.Errorf("store: %v", err)
 							continue
 						}
-
 						if advancedQuerier != nil {
 							advancedQuerier.AfterQuery(events, filter)
 						}
+						continue
+					}
 
-						// this block should not trigger if the SQL query accounts for filter.Limit
-						// other implementations may be broken, and this ensures the client
-						// won't be bombarded.
-						if filter.Limit > 0 && len(events) > filter.Limit {
-							events = events[0:filter.Limit]
-						}
+					events, err = StorageWithContext(store).QueryEventsCtx(ctx, filter)
+					if err != nil {
+						s.Log.Errorf("store: %v", err)
+						continue
+					}
 
-						for _, event := range events {
-							if event.Kind == 1 && s.ecdsaPvtKey != nil && len(event.Tags) > 0 {
-								for _, tag := range event.Tags {
-									if len(tag) >= 2 {
-										if tag[0] == "hash" {
-											b, err := hashutil.GetVerification(tag[1], hashutil.GetSha256([]byte(event.Content)), &s.ecdsaPvtKey.PublicKey)
-											if err != nil {
-												s.Log.ErrorfWithContext(ctx, "failed to verify sig: %v", err)
-											} else {
-												tag[2] = strconv.FormatBool(b)
-												bs := hashutil.GetSha256([]byte(hashutil.StringifyEvent(&event)))
-												event.ID = fmt.Sprintf("%x", bs)
-											}
-										}
-									}
-								}
-							}
+					if advancedQuerier != nil {
+						advancedQuerier.AfterQuery(events, filter)
+					}
 
-							s.Log.InfofWithContext(ctx, "sending EVENT ID: %s", id)
-							ws.WriteJSON([]interface{}{"EVENT", id, event})
-						}
+					// this block should not trigger if the SQL query accounts for filter.Limit
+					// other implementations may be broken, and this ensures the client
+					// won't be bombarded.
+					if filter.Limit > 0 && len(events) > filter.Limit {
+						events = events[0:filter.Limit]
+					}
+
+					for _, event := range events {
+						sendEvent(event)
 					}
-					// moved EOSE out of for loop.
-					// otherwise subscriptions may be cancelled too early
-					s.Log.InfofWithContext(ctx, "sending EOSE ID: %s", id)
-					ws.WriteJSON([]interface{}{"EOSE", id})
-					setListener(id, ws, filters)
-				case "CLOSE":
-					var id string
-					json.Unmarshal(request[1], &id)
-					if id == "" {
-						notice = "CLOSE has no <id>"
+				}
+				// moved EOSE out of for loop.
+				// otherwise subscriptions may be cancelled too early
+				s.Log.InfofWithContext(ctx, "sending EOSE ID: %s", id)
+				ws.WriteJSON([]interface{}{"EOSE", id})
+				setListener(id, ws, live)
+			case "COUNT":
+				var id string
+				json.Unmarshal(request[1], &id)
+				if id == "" {
+					notice = "COUNT has no <id>"
+					return
+				}
+
+				if s.limiter != nil {
+					if ok, message := s.limiter.allowReq(ctx, ws); !ok {
+						notice = message
 						return
 					}
+				}
 
-					removeListenerId(ws, id)
-				case "AUTH":
-					if auther, ok := s.relay.(Auther); ok {
-						var evt nostr.Event
-						if err := json.Unmarshal(request[1], &evt); err != nil {
-							notice = "failed to decode auth event: " + err.Error()
-							return
-						}
-						if pubkey, ok := nip42.ValidateAuthEvent(&evt, ws.challenge, auther.ServiceURL()); ok {
-							ws.authed = pubkey
-							ws.WriteJSON([]interface{}{"OK", evt.ID, true, "authentication success"})
-						} else {
-							ws.WriteJSON([]interface{}{"OK", evt.ID, false, "error: failed to authenticate"})
-						}
+				counter, isCounter := store.(Counter)
+				if s.host == nil && !isCounter {
+					notice = "error: this relay does not support COUNT"
+					return
+				}
+
+				// the union of several filters can't be counted from their
+				// counts, as events matching more than one would count twice
+				if len(request) < 3 {
+					notice = "COUNT has no filter"
+					return
+				}
+				if len(request) > 3 {
+					notice = "error: COUNT with more than one filter is not supported"
+					return
+				}
+				var filter nostr.Filter
+				if err := json.Unmarshal(request[2], &filter); err != nil {
+					notice = "failed to decode filter"
+					return
+				}
+				if notice = s.restrictFilter(ws, &filter); notice != "" {
+					return
+				}
+
+				var count int64
+				var approximate bool
+				var err error
+				if s.host != nil {
+					count, approximate, err = CountPeers(ctx, &filter, s.relay, s.client, span)
+				} else {
+					count, err = counter.CountEvents(ctx, &filter)
+				}
+				if err != nil {
+					s.Log.ErrorfWithContext(ctx, "count: %v", err)
+					notice = "error: failed to count events"
+					return
+				}
+				reply := map[string]any{"count": count}
+				if approximate {
+					reply["approximate"] = true
+				}
+				ws.WriteJSON([]interface{}{"COUNT", id, reply})
+			case "CLOSE":
+				var id string
+				json.Unmarshal(request[1], &id)
+				if id == "" {
+					notice = "CLOSE has no <id>"
+					return
+				}
+
+				removeListenerId(ws, id)
+			case "AUTH":
+				if auther, ok := s.relay.(Auther); ok {
+					var evt nostr.Event
+					if err := json.Unmarshal(request[1], &evt); err != nil {
+						notice = "failed to decode auth event: " + err.Error()
+						return
 					}
-				default:
-					if cwh, ok := s.relay.(CustomWebSocketHandler); ok {
-						cwh.HandleUnknownType(ws, typ, request)
+					if pubkey, ok := nip42.ValidateAuthEvent(&evt, ws.challenge, auther.ServiceURL()); ok {
+						ws.authed = pubkey
+						ws.WriteJSON([]interface{}{"OK", evt.ID, true, "authentication success"})
 					} else {
-						notice = "unknown message type " + typ
+						ws.WriteJSON([]interface{}{"OK", evt.ID, false, "error: failed to authenticate"})
 					}
 				}
-			}(message)
+			default:
+				if cwh, ok := s.relay.(CustomWebSocketHandler); ok {
+					cwh.HandleUnknownType(ws, typ, request)
+				} else {
+					notice = "unknown message type " + typ
+				}
+			}
+		}
+
+		queue := make(chan []byte, s.queueSize)
+		processed := make(chan struct{})
+		go func() {
+			defer close(processed)
+			s.process(ctx, queue, handleMessage)
+		}()
+		// the message being handled may still add a listener, it must be
+		// done before the listeners of ws are removed
+		defer func() {
+			cancel()
+			close(queue)
+			<-processed
+		}()
+
+		for {
+			ctx, span := s.tracer.Start(ctx, "handleWebsocket.reader.for")
+			span.SetAttributes(attribute.String("span_id", span.SpanContext().SpanID().String()))
+			s.Log.InfofWithContext(ctx, "inside for loop and waiting for message")
+			typ, message, err := conn.ReadMessage()
+			if err != nil {
+				if websocket.IsUnexpectedCloseError(
+					err,
+					websocket.CloseGoingAway,        // 1001
+					websocket.CloseNoStatusReceived, // 1005
+					websocket.CloseAbnormalClosure,  // 1006
+				) {
+					s.Log.WarningfWithContext(ctx, "unexpected close error from %s: %v", r.Header.Get("X-Forwarded-For"), err)
+				}
+				break
+			}
+
+			if typ == websocket.PingMessage {
+				ws.WriteMessage(websocket.PongMessage, nil)
+				continue
+			}
+
+			if !s.enqueue(ctx, ws, queue, message) {
+				span.End()
+				break
+			}
 			span.End()
 		}
 	}()
@@ -473,6 +593,63 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
 	}()
 }
 
+// fetchMedia downloads the media at url, giving up when ctx is done or after
+// the timeout of [WithMediaFetchTimeout], as it holds a worker meanwhile.
+func (s *Server) fetchMedia(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.mediaFetchTimeout)
+	defer cancel()
+	resp, err := req.C().R().SetContext(ctx).Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// restrictFilter returns why filter can't be served to ws, or "" when it can.
+// Kind-4 events are only served to their authenticated sender or receiver,
+// when authentication is a thing.
+func (s *Server) restrictFilter(ws *WebSocket, filter *nostr.Filter) string {
+	if _, ok := s.relay.(Auther); !ok || !slices.Contains(filter.Kinds, 4) {
+		return ""
+	}
+
+	senders := filter.Authors
+	receivers, _ := filter.Tags["p"]
+	switch {
+	case ws.authed == "":
+		// not authenticated
+		return "restricted: this relay does not serve kind-4 to unauthenticated users, does your client implement NIP-42?"
+	case len(senders) == 1 && len(receivers) < 2 && (senders[0] == ws.authed):
+		// allowed filter: ws.authed is sole sender (filter specifies one or all receivers)
+		return ""
+	case len(receivers) == 1 && len(senders) < 2 && (receivers[0] == ws.authed):
+		// allowed filter: ws.authed is sole receiver (filter specifies one or all senders)
+		return ""
+	default:
+		return "restricted: authenticated user does not have authorization for requested filters."
+	}
+}
+
+// supportsSearch reports whether REQ can be given a NIP-50 search. A hub can
+// when one of its peers can.
+func (s *Server) supportsSearch() bool {
+	if s.host == nil {
+		_, ok := s.relay.Storage().(Searcher)
+		return ok
+	}
+	reporter, ok := s.relay.(CapabilitiesReporter)
+	if !ok {
+		return false
+	}
+	for _, caps := range reporter.Capabilities() {
+		if caps.Supports(ql.MethodSearchEvents) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Server) handleNIP11(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -480,6 +657,12 @@ func (s *Server) handleNIP11(w http.ResponseWriter, r *http.Request) {
 	if _, ok := s.relay.(Auther); ok {
 		supportedNIPs = append(supportedNIPs, 42)
 	}
+	if _, ok := s.relay.Storage().(Counter); ok || s.host != nil {
+		supportedNIPs = append(supportedNIPs, 45)
+	}
+	if s.supportsSearch() {
+		supportedNIPs = append(supportedNIPs, 50)
+	}
 
 	info := nip11.RelayInformationDocument{
 		Name:          s.relay.Name(),
@@ -6,6 +6,8 @@ import (
 
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip11"
+	"github.com/sithumonline/demedia-nostr/relayer/ql"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
 )
 
 // Relay is the main interface for implementing a nostr relay.
@@ -29,12 +31,41 @@ type Relay interface {
 	Storage() Storage
 }
 
+// Rejecter is implemented by relays that tell why they don't accept an event.
+// RejectEvent is called before [Relay.AcceptEvent], a non-nil error is sent
+// in the OK message, so it should start with a NIP-20 prefix.
+type Rejecter interface {
+	RejectEvent(*nostr.Event) error
+}
+
 // Auther is the interface for implementing NIP-42.
 // ServiceURL() returns the URL used to verify the "AUTH" event from clients.
 type Auther interface {
 	ServiceURL() string
 }
 
+// Replicator is implemented by hub relays that keep each pubkey's events on
+// several peers. It only has an effect when the storage is a [PeerLister].
+type Replicator interface {
+	// ReplicationFactor is the number of peers an event is sent to.
+	ReplicationFactor() int
+	// WriteQuorum is how many of those peers must store the event before it is accepted.
+	WriteQuorum() int
+}
+
+// Outbox is implemented by hub relays that hold the events of offline peers.
+// When no peer serving an event's pubkey can be reached, the event is queued
+// with QueueEvent and the client is told it was accepted.
+type Outbox interface {
+	QueueEvent(evt nostr.Event) error
+}
+
+// Broadcaster is implemented by relays that pass on the events accepted on
+// their own websocket, such as the peers streaming them to their hub.
+type Broadcaster interface {
+	BroadcastEvent(evt nostr.Event)
+}
+
 type Injector interface {
 	InjectEvents() chan nostr.Event
 }
@@ -90,6 +121,62 @@ type Storage interface {
 	GetPeer(pubkey string) string
 }
 
+// StorageCtx is implemented by storages whose operations take a context, so
+// that they are cancelled when the client goes away and traced as part of its
+// request. The server prefers these methods to the ones of [Storage], see
+// [StorageWithContext].
+type StorageCtx interface {
+	QueryEventsCtx(ctx context.Context, filter *nostr.Filter) ([]nostr.Event, error)
+	DeleteEventCtx(ctx context.Context, id string, pubkey string) error
+	SaveEventCtx(ctx context.Context, event *nostr.Event) error
+}
+
+// PeerLister is implemented by storages that can return every peer serving a pubkey.
+type PeerLister interface {
+	// GetPeers returns up to n addresses of the peers serving pubkey, all of
+	// them when n is 0. Unlike [Storage.GetPeer], it returns none when pubkey
+	// has no peer.
+	GetPeers(pubkey string, n int) []string
+	// GetAllPeers returns the address of every live peer.
+	GetAllPeers() []string
+}
+
+// QueryLimiter is implemented by storages bounding the events returned for a
+// filter. The limits of [WithQueryLimits] are passed to SetQueryLimits before
+// [Storage.Init].
+type QueryLimiter interface {
+	SetQueryLimits(limits storage.QueryLimits)
+}
+
+// EventStreamer is implemented by storages that can hand out the results of a
+// query one event at a time, instead of loading them all in memory.
+type EventStreamer interface {
+	// StreamEvents calls send for each event matching filter, newest first,
+	// stopping at the first error it returns or once ctx is done.
+	StreamEvents(ctx context.Context, filter *nostr.Filter, send func(nostr.Event) error) error
+}
+
+// Counter is implemented by storages that can count the events matching a
+// filter without loading them, to answer COUNT requests (NIP-45).
+type Counter interface {
+	// CountEvents counts the events matching filter, ignoring its limit.
+	CountEvents(ctx context.Context, filter *nostr.Filter) (int64, error)
+}
+
+// Searcher is implemented by storages that support full-text search (NIP-50).
+type Searcher interface {
+	// SearchEvents returns the events matching filter whose content matches
+	// search, most relevant first.
+	SearchEvents(ctx context.Context, filter *nostr.Filter, search string) ([]storage.SearchResult, error)
+}
+
+// CapabilitiesReporter is implemented by hub relays that know what their peers
+// support, to advertise it in NIP-11.
+type CapabilitiesReporter interface {
+	// Capabilities returns the known capabilities of every peer, by address.
+	Capabilities() map[string]ql.Capabilities
+}
+
 // AdvancedQuerier methods are called before and after [Storage.QueryEvents].
 type AdvancedQuerier interface {
 	BeforeQuery(*nostr.Filter)
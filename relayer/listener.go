@@ -13,6 +13,18 @@ type Listener struct {
 var listeners = make(map[*WebSocket]map[string]*Listener)
 var listenersMutex = sync.Mutex{}
 
+// listenersChanged is signaled whenever a subscription is added or removed,
+// so the hub can push its new filters to the peers.
+var listenersChanged = make(chan struct{}, 1)
+
+// an event may reach the hub both from a client and from the peers it was
+// replicated to, the last notified ids are kept to send it only once.
+const recentNotifiedSize = 1024
+
+var recentNotified = make(map[string]struct{}, recentNotifiedSize)
+var recentNotifiedRing = make([]string, recentNotifiedSize)
+var recentNotifiedNext = 0
+
 func GetListeningFilters() nostr.Filters {
 	var respfilters = make(nostr.Filters, 0, len(listeners)*2)
 
@@ -61,6 +73,7 @@ func setListener(id string, ws *WebSocket, filters nostr.Filters) {
 	subs[id] = &Listener{
 		filters: filters,
 	}
+	signalListenersChanged()
 }
 
 // Remove a specific subscription id from listeners for a given ws client
@@ -76,6 +89,7 @@ func removeListenerId(ws *WebSocket, id string) {
 		if len(subs) == 0 {
 			delete(listeners, ws)
 		}
+		signalListenersChanged()
 	}
 }
 
@@ -87,6 +101,14 @@ func removeListener(ws *WebSocket) {
 	_, ok := listeners[ws]
 	if ok {
 		delete(listeners, ws)
+		signalListenersChanged()
+	}
+}
+
+func signalListenersChanged() {
+	select {
+	case listenersChanged <- struct{}{}:
+	default:
 	}
 }
 
@@ -96,6 +118,14 @@ func notifyListeners(event *nostr.Event) {
 		listenersMutex.Unlock()
 	}()
 
+	if _, seen := recentNotified[event.ID]; seen {
+		return
+	}
+	delete(recentNotified, recentNotifiedRing[recentNotifiedNext])
+	recentNotifiedRing[recentNotifiedNext] = event.ID
+	recentNotified[event.ID] = struct{}{}
+	recentNotifiedNext = (recentNotifiedNext + 1) % recentNotifiedSize
+
 	for ws, subs := range listeners {
 		for id, listener := range subs {
 			if !listener.filters.Match(event) {
@@ -0,0 +1,117 @@
+package relayer
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/ql"
+)
+
+// how often the hub looks for peers that came online or went away
+const liveReconcileInterval = 5 * time.Second
+
+type liveStream struct {
+	address string
+	filters chan ql.BridgeArgs
+}
+
+// followPeers keeps a live stream open to every registered peer. The peers get
+// the filters of the active subscriptions and stream back the new events matching
+// them, which are delivered to the subscribers like any other event.
+func (s *Server) followPeers(lister PeerLister) {
+	streams := make(map[string]*liveStream)
+	ended := make(chan *liveStream)
+	ticker := time.NewTicker(liveReconcileInterval)
+	defer ticker.Stop()
+
+	current := func() ql.BridgeArgs {
+		b, err := json.Marshal(GetListeningFilters())
+		if err != nil {
+			s.Log.Errorf("failed to encode live filters: %v", err)
+		}
+		return ql.BridgeArgs{Data: b}
+	}
+
+	reconcile := func() {
+		live := make(map[string]struct{})
+		for _, address := range lister.GetAllPeers() {
+			live[address] = struct{}{}
+			if _, ok := streams[address]; ok {
+				continue
+			}
+			ls := &liveStream{address: address, filters: make(chan ql.BridgeArgs, 1)}
+			ls.filters <- current()
+			streams[address] = ls
+			go func() {
+				s.follow(ls)
+				ended <- ls
+			}()
+		}
+		for address, ls := range streams {
+			if _, ok := live[address]; !ok {
+				delete(streams, address)
+				close(ls.filters)
+			}
+		}
+	}
+
+	reconcile()
+	for {
+		select {
+		case <-ticker.C:
+			reconcile()
+		case <-listenersChanged:
+			args := current()
+			for _, ls := range streams {
+				offer(ls.filters, args)
+			}
+		case ls := <-ended:
+			// reopened on the next reconcile if the peer is still registered
+			if streams[ls.address] == ls {
+				delete(streams, ls.address)
+				close(ls.filters)
+			}
+		}
+	}
+}
+
+// follow streams the events of one peer until the stream ends. Their
+// signatures were checked by the websocket they were published on, and the
+// events the hub rewrote before saving them don't have a valid one anymore,
+// so the authenticated stream of a registered peer is trusted as it is.
+func (s *Server) follow(ls *liveStream) {
+	events := make(chan ql.BridgeReply, 64)
+	go func() {
+		for reply := range events {
+			var evt nostr.Event
+			if err := json.Unmarshal(reply.Data, &evt); err != nil {
+				s.Log.Warningf("invalid live event from %s: %v", ls.address, err)
+				continue
+			}
+			notifyListeners(&evt)
+		}
+	}()
+
+	err := s.client.Live(context.Background(), ls.address, ls.filters, events)
+	if err != nil {
+		s.Log.Warningf("live stream to %s ended: %v", ls.address, err)
+	}
+}
+
+// offer replaces whatever is still pending on ch with v, the peers only
+// care about the latest set of filters.
+func offer(ch chan ql.BridgeArgs, v ql.BridgeArgs) {
+	for {
+		select {
+		case ch <- v:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package relayer
+
+import (
+	"testing"
+
+	"github.com/sithumonline/demedia-nostr/relayer/ql"
+)
+
+func TestOfferKeepsLatest(t *testing.T) {
+	ch := make(chan ql.BridgeArgs, 1)
+	offer(ch, ql.BridgeArgs{Data: []byte("1")})
+	offer(ch, ql.BridgeArgs{Data: []byte("2")})
+	offer(ch, ql.BridgeArgs{Data: []byte("3")})
+
+	if got := string((<-ch).Data); got != "3" {
+		t.Errorf("got %q, want the latest filters", got)
+	}
+	select {
+	case v := <-ch:
+		t.Errorf("unexpected pending filters %q", v.Data)
+	default:
+	}
+}
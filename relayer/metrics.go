@@ -0,0 +1,109 @@
+package relayer
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// meter records the metrics of the relay. It follows the global meter
+// provider, so it may be used before the provider is set.
+var meter = otel.Meter("github.com/sithumonline/demedia-nostr/relayer")
+
+var (
+	eventsCounter   metric.Int64Counter
+	storageDuration metric.Float64Histogram
+)
+
+func init() {
+	eventsCounter, _ = meter.Int64Counter("relayer.events",
+		metric.WithDescription("Events published by clients, by result and reason"))
+	storageDuration, _ = meter.Float64Histogram("relayer.storage.duration",
+		metric.WithDescription("Duration of the storage calls, by operation"),
+		metric.WithUnit("s"))
+	meter.Int64ObservableGauge("relayer.subscriptions",
+		metric.WithDescription("Subscriptions open on the websocket connections"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			listenersMutex.Lock()
+			n := 0
+			for _, connlisteners := range listeners {
+				n += len(connlisteners)
+			}
+			listenersMutex.Unlock()
+			o.Observe(int64(n))
+			return nil
+		}))
+}
+
+// registerGauges reports the connected websockets of s and, on a hub, the
+// peers it knows of.
+func (s *Server) registerGauges() {
+	meter.Int64ObservableGauge("relayer.websocket.connections",
+		metric.WithDescription("Connected websocket clients"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			s.clientsMu.Lock()
+			n := len(s.clients)
+			s.clientsMu.Unlock()
+			o.Observe(int64(n))
+			return nil
+		}))
+
+	if lister, ok := s.relay.Storage().(PeerLister); ok && s.host != nil {
+		meter.Int64ObservableGauge("relayer.peers.registered",
+			metric.WithDescription("Live peers in the registry of the hub"),
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				o.Observe(int64(len(lister.GetAllPeers())))
+				return nil
+			}))
+	}
+}
+
+// replyOK answers an EVENT with a NIP-20 OK message, counting the event as
+// accepted or rejected for the prefix of message.
+func (s *Server) replyOK(ctx context.Context, ws *WebSocket, id string, ok bool, message string) {
+	result, reason := "accepted", ""
+	if prefix, _, found := strings.Cut(message, ": "); found && !strings.Contains(prefix, " ") {
+		reason = prefix
+	}
+	if !ok {
+		result = "rejected"
+		if reason == "" {
+			reason = "error"
+		}
+	}
+	eventsCounter.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("result", result),
+		attribute.String("reason", reason),
+	))
+	ws.WriteJSON([]interface{}{"OK", id, ok, message})
+}
+
+// timedStorage records the duration of the calls of a StorageCtx.
+type timedStorage struct {
+	StorageCtx
+}
+
+func observeStorage(ctx context.Context, op string, start time.Time) {
+	storageDuration.Record(ctx, time.Since(start).Seconds(),
+		metric.WithAttributes(attribute.String("operation", op)))
+}
+
+func (s timedStorage) QueryEventsCtx(ctx context.Context, filter *nostr.Filter) ([]nostr.Event, error) {
+	defer observeStorage(ctx, "query", time.Now())
+	return s.StorageCtx.QueryEventsCtx(ctx, filter)
+}
+
+func (s timedStorage) DeleteEventCtx(ctx context.Context, id string, pubkey string) error {
+	defer observeStorage(ctx, "delete", time.Now())
+	return s.StorageCtx.DeleteEventCtx(ctx, id, pubkey)
+}
+
+func (s timedStorage) SaveEventCtx(ctx context.Context, event *nostr.Event) error {
+	defer observeStorage(ctx, "save", time.Now())
+	return s.StorageCtx.SaveEventCtx(ctx, event)
+}
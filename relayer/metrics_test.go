@@ -0,0 +1,80 @@
+package relayer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestEventMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+
+	srv := startTestRelay(t, &testRelay{
+		storage:     &testStorage{},
+		acceptEvent: func(evt *nostr.Event) bool { return evt.Content != "spam" },
+	})
+	defer srv.Shutdown(context.Background())
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+srv.Addr(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	for _, content := range []string{"hello", "spam", "spam"} {
+		evt := nostr.Event{PubKey: pk, Kind: 1, Content: content, CreatedAt: time.Now()}
+		if err := evt.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.WriteJSON([]interface{}{"EVENT", evt}); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var reply []interface{}
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int64{}
+	connections := int64(-1)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				if m.Name != "relayer.events" {
+					continue
+				}
+				for _, dp := range data.DataPoints {
+					result, _ := dp.Attributes.Value(attribute.Key("result"))
+					reason, _ := dp.Attributes.Value(attribute.Key("reason"))
+					got[result.AsString()+"/"+reason.AsString()] = dp.Value
+				}
+			case metricdata.Gauge[int64]:
+				if m.Name == "relayer.websocket.connections" {
+					connections = data.DataPoints[0].Value
+				}
+			}
+		}
+	}
+	if got["accepted/"] != 1 || got["rejected/blocked"] != 2 {
+		t.Errorf("got events %v, want 1 accepted and 2 blocked", got)
+	}
+	if connections != 1 {
+		t.Errorf("got %d connections, want 1", connections)
+	}
+}
@@ -0,0 +1,113 @@
+package relayer
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
+)
+
+// Defaults of the Server options.
+const (
+	// Time allowed to read the next pong message from the client.
+	DefaultPongWait = 60 * time.Second
+
+	// Maximum message size allowed from the client.
+	DefaultMaxMessageSize = 512000
+
+	// Sizes of the websocket I/O buffers.
+	DefaultReadBufferSize  = 1024
+	DefaultWriteBufferSize = 1024
+
+	// Messages of a connection waiting to be handled, it is closed past them.
+	DefaultQueueSize = 64
+
+	// Messages handled at once, for all connections.
+	DefaultWorkers = 256
+
+	// Time allowed for a peer to answer a call.
+	DefaultPeerCallTimeout = 10 * time.Second
+
+	// Time allowed for the peers of a scattered query to send their next event.
+	DefaultPeerQueryTimeout = 5 * time.Second
+
+	// Time allowed to download the media of an event.
+	DefaultMediaFetchTimeout = 30 * time.Second
+)
+
+// Option configures a Server, see NewServer.
+type Option func(*Server)
+
+// WithPongWait sets how long a client may stay silent before it is
+// disconnected. It is pinged twice in that time.
+func WithPongWait(d time.Duration) Option {
+	return func(s *Server) { s.pongWait = d }
+}
+
+// WithMaxMessageSize sets the largest message read from a client, bigger ones
+// close the connection.
+func WithMaxMessageSize(n int64) Option {
+	return func(s *Server) { s.maxMessageSize = n }
+}
+
+// WithBufferSizes sets the sizes of the websocket I/O buffers.
+func WithBufferSizes(read, write int) Option {
+	return func(s *Server) {
+		s.upgrader.ReadBufferSize = read
+		s.upgrader.WriteBufferSize = write
+	}
+}
+
+// WithQueueSize sets how many messages of a connection may wait to be
+// handled. A client sending more is disconnected.
+func WithQueueSize(n int) Option {
+	return func(s *Server) { s.queueSize = n }
+}
+
+// WithWorkers sets how many messages are handled at once, for all
+// connections together. n <= 0 keeps DefaultWorkers.
+func WithWorkers(n int) Option {
+	return func(s *Server) {
+		if n <= 0 {
+			n = DefaultWorkers
+		}
+		s.workers = make(chan struct{}, n)
+	}
+}
+
+// WithPeerTimeouts sets how long a peer may take to answer a call, and to
+// send the next event of a query.
+func WithPeerTimeouts(call, query time.Duration) Option {
+	return func(s *Server) {
+		s.peerTimeouts = peerTimeouts{call: call, query: query}
+	}
+}
+
+// WithMediaFetchTimeout sets how long the media of an event may take to
+// download, a worker is held meanwhile.
+func WithMediaFetchTimeout(d time.Duration) Option {
+	return func(s *Server) { s.mediaFetchTimeout = d }
+}
+
+// WithQueryLimits sets the most events the storage returns for a filter,
+// whatever its limit, when it is a [QueryLimiter].
+func WithQueryLimits(queried, streamed int) Option {
+	return func(s *Server) {
+		s.queryLimits = storage.QueryLimits{MaxQueriedEvents: queried, MaxStreamedEvents: streamed}
+	}
+}
+
+func defaultOptions(s *Server) {
+	s.pongWait = DefaultPongWait
+	s.maxMessageSize = DefaultMaxMessageSize
+	s.queueSize = DefaultQueueSize
+	s.workers = make(chan struct{}, DefaultWorkers)
+	s.peerTimeouts = peerTimeouts{call: DefaultPeerCallTimeout, query: DefaultPeerQueryTimeout}
+	s.mediaFetchTimeout = DefaultMediaFetchTimeout
+	s.upgrader = websocket.Upgrader{
+		ReadBufferSize:  DefaultReadBufferSize,
+		WriteBufferSize: DefaultWriteBufferSize,
+		CheckOrigin:     func(r *http.Request) bool { return true },
+	}
+}
@@ -0,0 +1,246 @@
+// Package policy decides which events a relay accepts, from rules an operator
+// sets in a JSON file. Every rejection is a [ql.Error], so that its NIP-20
+// reason reaches the client whether the event was refused by the hub or by a
+// peer.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+	"github.com/sithumonline/demedia-nostr/relayer/ql"
+)
+
+// Config holds the rules of a [Policy]. Zero values disable a rule.
+type Config struct {
+	// largest JSON encoding of an event, in bytes
+	MaxSize int `json:"max_size"`
+
+	// when set, only these kinds are accepted
+	AllowedKinds []int `json:"allowed_kinds"`
+	DeniedKinds  []int `json:"denied_kinds"`
+
+	// when set, only the events of these pubkeys are accepted
+	AllowedPubKeys []string `json:"allowed_pubkeys"`
+	DeniedPubKeys  []string `json:"denied_pubkeys"`
+
+	MaxTags int `json:"max_tags"`
+
+	// how far created_at may be from now, like "1h"
+	MaxPastDrift   Duration `json:"max_past_drift"`
+	MaxFutureDrift Duration `json:"max_future_drift"`
+
+	// NIP-13 difficulty, in leading zero bits of the id
+	MinPoW int `json:"min_pow"`
+
+	// longest content by kind
+	MaxContentLength map[int]int `json:"max_content_length"`
+}
+
+// DefaultConfig is used when no file is given, and completed by the file
+// otherwise. It keeps the historical size limit.
+var DefaultConfig = Config{
+	MaxSize: 10000,
+}
+
+// Duration is a time.Duration read from a string such as "15m".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Rule returns why evt is rejected, or nil.
+type Rule func(evt *nostr.Event) error
+
+// Policy is a set of rules, an event is accepted when it breaks none of them.
+type Policy struct {
+	rules []Rule
+}
+
+// Load reads the config at path over DefaultConfig, path may be empty.
+func Load(path string) (*Policy, error) {
+	cfg := DefaultConfig
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+		}
+	}
+	return New(cfg), nil
+}
+
+// New composes the rules set in cfg. Cheap rules come first.
+func New(cfg Config) *Policy {
+	p := &Policy{}
+	if len(cfg.AllowedPubKeys) > 0 {
+		p.Add(allowPubKeys(cfg.AllowedPubKeys))
+	}
+	if len(cfg.DeniedPubKeys) > 0 {
+		p.Add(denyPubKeys(cfg.DeniedPubKeys))
+	}
+	if len(cfg.AllowedKinds) > 0 {
+		p.Add(allowKinds(cfg.AllowedKinds))
+	}
+	if len(cfg.DeniedKinds) > 0 {
+		p.Add(denyKinds(cfg.DeniedKinds))
+	}
+	if cfg.MaxPastDrift > 0 || cfg.MaxFutureDrift > 0 {
+		p.Add(createdAtDrift(time.Duration(cfg.MaxPastDrift), time.Duration(cfg.MaxFutureDrift)))
+	}
+	if cfg.MaxTags > 0 {
+		p.Add(maxTags(cfg.MaxTags))
+	}
+	if len(cfg.MaxContentLength) > 0 {
+		p.Add(maxContentLength(cfg.MaxContentLength))
+	}
+	if cfg.MinPoW > 0 {
+		p.Add(minPoW(cfg.MinPoW))
+	}
+	if cfg.MaxSize > 0 {
+		p.Add(maxSize(cfg.MaxSize))
+	}
+	return p
+}
+
+// Add appends rule to the ones of p.
+func (p *Policy) Add(rule Rule) {
+	p.rules = append(p.rules, rule)
+}
+
+// Check returns the error of the first rule evt breaks, or nil when it is
+// accepted.
+func (p *Policy) Check(evt *nostr.Event) error {
+	for _, rule := range p.rules {
+		if err := rule(evt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func set[T comparable](values []T) map[T]struct{} {
+	m := make(map[T]struct{}, len(values))
+	for _, v := range values {
+		m[v] = struct{}{}
+	}
+	return m
+}
+
+func allowPubKeys(pubkeys []string) Rule {
+	allowed := set(pubkeys)
+	return func(evt *nostr.Event) error {
+		if _, ok := allowed[evt.PubKey]; !ok {
+			return ql.NewError(ql.CodeBlocked, "pubkey %s is not allowed", evt.PubKey)
+		}
+		return nil
+	}
+}
+
+func denyPubKeys(pubkeys []string) Rule {
+	denied := set(pubkeys)
+	return func(evt *nostr.Event) error {
+		if _, ok := denied[evt.PubKey]; ok {
+			return ql.NewError(ql.CodeBlocked, "pubkey %s is banned", evt.PubKey)
+		}
+		return nil
+	}
+}
+
+func allowKinds(kinds []int) Rule {
+	allowed := set(kinds)
+	return func(evt *nostr.Event) error {
+		if _, ok := allowed[evt.Kind]; !ok {
+			return ql.NewError(ql.CodeBlocked, "kind %d is not accepted", evt.Kind)
+		}
+		return nil
+	}
+}
+
+func denyKinds(kinds []int) Rule {
+	denied := set(kinds)
+	return func(evt *nostr.Event) error {
+		if _, ok := denied[evt.Kind]; ok {
+			return ql.NewError(ql.CodeBlocked, "kind %d is not accepted", evt.Kind)
+		}
+		return nil
+	}
+}
+
+func createdAtDrift(past, future time.Duration) Rule {
+	return func(evt *nostr.Event) error {
+		now := time.Now()
+		if past > 0 && evt.CreatedAt.Before(now.Add(-past)) {
+			return ql.NewError(ql.CodeInvalid, "created_at is more than %s in the past", past)
+		}
+		if future > 0 && evt.CreatedAt.After(now.Add(future)) {
+			return ql.NewError(ql.CodeInvalid, "created_at is more than %s in the future", future)
+		}
+		return nil
+	}
+}
+
+func maxTags(max int) Rule {
+	return func(evt *nostr.Event) error {
+		if len(evt.Tags) > max {
+			return ql.NewError(ql.CodeInvalid, "too many tags, %d is the most", max)
+		}
+		return nil
+	}
+}
+
+func maxContentLength(byKind map[int]int) Rule {
+	return func(evt *nostr.Event) error {
+		if max, ok := byKind[evt.Kind]; ok && len(evt.Content) > max {
+			return ql.NewError(ql.CodeInvalid, "content of kind %d is longer than %d bytes", evt.Kind, max)
+		}
+		return nil
+	}
+}
+
+// minPoW also checks the target committed in the nonce tag, so that events
+// aimed at a lower difficulty aren't accepted for being lucky.
+func minPoW(difficulty int) Rule {
+	return func(evt *nostr.Event) error {
+		if nip13.Difficulty(evt.ID) < difficulty {
+			return ql.NewError(ql.CodePow, "difficulty is less than %d", difficulty)
+		}
+		if nonce := evt.Tags.GetFirst([]string{"nonce"}); nonce != nil && len(*nonce) >= 3 {
+			if target, err := strconv.Atoi((*nonce)[2]); err == nil && target < difficulty {
+				return ql.NewError(ql.CodePow, "committed target %d is less than %d", target, difficulty)
+			}
+		}
+		return nil
+	}
+}
+
+func maxSize(max int) Rule {
+	return func(evt *nostr.Event) error {
+		jsonb, _ := json.Marshal(evt)
+		if len(jsonb) > max {
+			return ql.NewError(ql.CodeInvalid, "event is larger than %d bytes", max)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,68 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	config := `{
+		"denied_kinds": [7],
+		"denied_pubkeys": ["mallory"],
+		"max_tags": 2,
+		"max_future_drift": "15m",
+		"max_content_length": {"1": 10}
+	}`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for _, tc := range []struct {
+		evt  nostr.Event
+		want string
+	}{
+		{nostr.Event{PubKey: "alice", Kind: 1, CreatedAt: now, Content: "hello"}, ""},
+		{nostr.Event{PubKey: "alice", Kind: 7, CreatedAt: now}, "blocked: "},
+		{nostr.Event{PubKey: "mallory", Kind: 1, CreatedAt: now}, "blocked: "},
+		{nostr.Event{PubKey: "alice", Kind: 1, CreatedAt: now, Tags: nostr.Tags{{"t", "a"}, {"t", "b"}, {"t", "c"}}}, "invalid: "},
+		{nostr.Event{PubKey: "alice", Kind: 1, CreatedAt: now.Add(time.Hour)}, "invalid: "},
+		{nostr.Event{PubKey: "alice", Kind: 1, CreatedAt: now, Content: "way too long"}, "invalid: "},
+		{nostr.Event{PubKey: "alice", Kind: 30023, CreatedAt: now, Content: "way too long"}, ""},
+		// the default size limit is kept
+		{nostr.Event{PubKey: "alice", Kind: 30023, CreatedAt: now, Content: strings.Repeat("a", 10001)}, "invalid: "},
+	} {
+		err := p.Check(&tc.evt)
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("%+v: unexpected %v", tc.evt, err)
+		case tc.want != "" && (err == nil || !strings.HasPrefix(err.Error(), tc.want)):
+			t.Errorf("%+v: got %v, want a %q error", tc.evt, err, tc.want)
+		}
+	}
+}
+
+func TestMinPoW(t *testing.T) {
+	p := New(Config{MinPoW: 8})
+	id := "00" + strings.Repeat("f", 62)
+	if err := p.Check(&nostr.Event{ID: id}); err != nil {
+		t.Errorf("unexpected %v", err)
+	}
+	if err := p.Check(&nostr.Event{ID: strings.Repeat("f", 64)}); err == nil || !strings.HasPrefix(err.Error(), "pow: ") {
+		t.Errorf("got %v, want a pow error", err)
+	}
+	lucky := nostr.Event{ID: id, Tags: nostr.Tags{{"nonce", "1", "4"}}}
+	if err := p.Check(&lucky); err == nil {
+		t.Error("a lower committed target was accepted")
+	}
+}
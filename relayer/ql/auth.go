@@ -0,0 +1,23 @@
+package ql
+
+import "context"
+
+type authedKey struct{}
+
+// WithAuthed returns a copy of ctx carrying pubkey, the client authenticated
+// with NIP-42 the calls are made for. It is sent to the peers in [Meta].
+func WithAuthed(ctx context.Context, pubkey string) context.Context {
+	if pubkey == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, authedKey{}, pubkey)
+}
+
+// Authed returns the pubkey set by WithAuthed, or "" when there is none.
+func Authed(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	pubkey, _ := ctx.Value(authedKey{}).(string)
+	return pubkey
+}
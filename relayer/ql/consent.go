@@ -0,0 +1,48 @@
+package ql
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// KindHostingConsent is the kind of the event a user signs to let a peer host
+// their events. The event must carry a "p" tag with the peer's pubkey, and
+// may carry a NIP-40 "expiration" tag past which it is no longer valid.
+const KindHostingConsent = 10880
+
+// VerifyConsent checks that evt is a valid, unexpired consent for the peer
+// identified by host and returns the pubkey that gave it.
+func VerifyConsent(evt *nostr.Event, host string) (string, error) {
+	if evt.Kind != KindHostingConsent {
+		return "", fmt.Errorf("consent has kind %d, want %d", evt.Kind, KindHostingConsent)
+	}
+	if evt.GetID() != evt.ID {
+		return "", errors.New("consent id does not match its content")
+	}
+	if ok, err := evt.CheckSignature(); err != nil {
+		return "", fmt.Errorf("consent signature: %w", err)
+	} else if !ok {
+		return "", errors.New("consent signature is invalid")
+	}
+
+	p := evt.Tags.GetFirst([]string{"p", host})
+	if p == nil || p.Value() != host {
+		return "", fmt.Errorf("consent of %s is not for %s", evt.PubKey, host)
+	}
+
+	if exp := evt.Tags.GetFirst([]string{"expiration", ""}); exp != nil {
+		ts, err := strconv.ParseInt(exp.Value(), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("consent of %s has an invalid expiration %q", evt.PubKey, exp.Value())
+		}
+		if time.Now().Unix() >= ts {
+			return "", fmt.Errorf("consent of %s expired", evt.PubKey)
+		}
+	}
+
+	return evt.PubKey, nil
+}
@@ -0,0 +1,89 @@
+package ql
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// unreachableError marks QlCall failures where the peer could not be reached,
+// as opposed to the peer answering with an error.
+type unreachableError struct {
+	err error
+}
+
+func (e unreachableError) Error() string { return e.err.Error() }
+func (e unreachableError) Unwrap() error { return e.err }
+
+// IsUnreachable reports whether err was returned because the peer is offline.
+func IsUnreachable(err error) bool {
+	var u unreachableError
+	return errors.As(err, &u)
+}
+
+// ErrorCode classifies the errors returned by ProtocolV2 calls, each one maps
+// to a NIP-20 OK message prefix.
+type ErrorCode int
+
+const (
+	CodeError ErrorCode = iota
+	CodeInvalid
+	CodeBlocked
+	CodeDuplicate
+	CodePow
+	CodeRateLimited
+	CodeRestricted
+	CodeUnknownMethod
+)
+
+var codePrefixes = map[ErrorCode]string{
+	CodeError:         "error",
+	CodeInvalid:       "invalid",
+	CodeBlocked:       "blocked",
+	CodeDuplicate:     "duplicate",
+	CodePow:           "pow",
+	CodeRateLimited:   "rate-limited",
+	CodeRestricted:    "restricted",
+	CodeUnknownMethod: "error",
+}
+
+// Prefix returns the NIP-20 prefix of c, "error" for unknown codes.
+func (c ErrorCode) Prefix() string {
+	if p, ok := codePrefixes[c]; ok {
+		return p
+	}
+	return "error"
+}
+
+// Error is the structured error a peer answers a ProtocolV2 call with.
+// Its message is ready to be sent in an OK or NOTICE.
+type Error struct {
+	Code    ErrorCode
+	Message string
+}
+
+func NewError(code ErrorCode, format string, v ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, v...)}
+}
+
+func (e *Error) Error() string { return e.Code.Prefix() + ": " + e.Message }
+
+// AsError turns err into an *Error. Errors without a code get one from their
+// NIP-20 prefix, if any, and CodeError otherwise. It returns nil for a nil err.
+func AsError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	msg := err.Error()
+	for _, code := range []ErrorCode{CodeInvalid, CodeBlocked, CodeDuplicate, CodePow, CodeRateLimited, CodeRestricted, CodeError} {
+		if p := code.Prefix() + ":"; strings.HasPrefix(msg, p) {
+			return &Error{Code: code, Message: strings.TrimSpace(strings.TrimPrefix(msg, p))}
+		}
+	}
+	return &Error{Code: CodeError, Message: msg}
+}
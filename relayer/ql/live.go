@@ -0,0 +1,35 @@
+package ql
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p-gorpc"
+)
+
+// LiveProtocol is the protocol of the stream a hub opens to each peer to
+// receive the new events matching its subscriptions.
+const LiveProtocol = "/p2p/live/1.0.0"
+
+// Live opens a LiveService.Subscribe stream to the peer at peerAddr.
+// The hub sends its active filters as JSON on filters and receives the
+// matching events as JSON on events, which is closed when the stream ends.
+// Live blocks until then.
+func (c *Client) Live(
+	ctx context.Context,
+	peerAddr string,
+	filters chan BridgeArgs,
+	events chan BridgeReply,
+) error {
+	id, err := c.connect(ctx, peerAddr)
+	if err != nil {
+		close(events)
+		return err
+	}
+
+	err = c.live.Stream(ctx, id, "LiveService", "Subscribe", filters, events)
+	if err != nil && rpc.IsClientError(err) {
+		c.forget(peerAddr)
+		return unreachableError{err}
+	}
+	return err
+}
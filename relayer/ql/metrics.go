@@ -0,0 +1,42 @@
+package ql
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var (
+	callDuration metric.Float64Histogram
+	callErrors   metric.Int64Counter
+)
+
+func init() {
+	meter := otel.Meter("github.com/sithumonline/demedia-nostr/relayer/ql")
+	callDuration, _ = meter.Float64Histogram("ql.client.duration",
+		metric.WithDescription("Duration of the calls to the peers, by peer and method"),
+		metric.WithUnit("s"))
+	callErrors, _ = meter.Int64Counter("ql.client.errors",
+		metric.WithDescription("Failed calls to the peers, by peer, method and reason"))
+}
+
+// observe records a call of method to the peer at peerAddr started at start,
+// which failed with *err if set. It is meant to be deferred.
+func observe(ctx context.Context, peerAddr string, method string, start time.Time, err *error) {
+	attrs := []attribute.KeyValue{
+		attribute.String("peer", peerAddr),
+		attribute.String("method", method),
+	}
+	callDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attrs...))
+	if *err == nil {
+		return
+	}
+	reason := "unreachable"
+	if !IsUnreachable(*err) {
+		reason = AsError(*err).Code.Prefix()
+	}
+	callErrors.Add(ctx, 1, metric.WithAttributes(append(attrs, attribute.String("reason", reason))...))
+}
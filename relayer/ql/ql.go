@@ -4,9 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/libp2p/go-libp2p-gorpc"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
 	madns "github.com/multiformats/go-multiaddr-dns"
@@ -14,8 +17,48 @@ import (
 	"go.opentelemetry.io/otel/trace"
 )
 
-func QlCall(
-	h host.Host,
+// How long a resolved peer address is reused before resolving it again.
+const addrCacheTTL = 10 * time.Minute
+
+type cachedAddr struct {
+	info    peer.AddrInfo
+	expires time.Time
+}
+
+// Client calls the services of other hosts over the Ql protocols. It caches
+// the resolved peer addresses and reuses the open connections, and is safe
+// for concurrent use.
+//
+// Calls are bounded by their ctx only, give it a deadline to time them out.
+type Client struct {
+	h host.Host
+
+	v1   *rpc.Client
+	v2   *rpc.Client
+	live *rpc.Client
+
+	mu    sync.Mutex
+	addrs map[string]cachedAddr
+}
+
+func NewClient(h host.Host) *Client {
+	return &Client{
+		h:     h,
+		v1:    rpc.NewClient(h, ProtocolV1),
+		v2:    rpc.NewClient(h, ProtocolV2),
+		live:  rpc.NewClient(h, LiveProtocol),
+		addrs: make(map[string]cachedAddr),
+	}
+}
+
+// Host returns the libp2p host the client calls from.
+func (c *Client) Host() host.Host {
+	return c.h
+}
+
+// Call calls serviceName.serviceMethod of the peer at peerAddr on ProtocolV1,
+// with input as the body of a BridgeCall for method.
+func (c *Client) Call(
 	ctx context.Context,
 	input interface{},
 	peerAddr string,
@@ -32,28 +75,83 @@ func QlCall(
 		return BridgeReply{}, fmt.Errorf("QlCall, json marshal input: %w", err)
 	}
 
-	ma, err := multiaddr.NewMultiaddr(peerAddr)
+	id, err := c.connect(ctx, peerAddr)
 	if err != nil {
 		return BridgeReply{}, err
 	}
-	addrs, err := madns.Resolve(context.Background(), ma)
+
+	args, err := bridgeArgs(ctx, method, body, span)
 	if err != nil {
-		return BridgeReply{}, fmt.Errorf("QlCall, DNS resolve: %w", err)
+		return BridgeReply{}, err
 	}
-	if len(addrs) != 1 {
-		return BridgeReply{}, fmt.Errorf("QlCall, DNS address count: %d", len(addrs))
+
+	var reply BridgeReply
+
+	err = c.v1.CallContext(
+		ctx,
+		id,
+		serviceName,
+		serviceMethod,
+		args,
+		&reply,
+	)
+	if err != nil {
+		if rpc.IsClientError(err) {
+			c.forget(peerAddr)
+			return BridgeReply{}, unreachableError{fmt.Errorf("QlCall, rpcClient call: %w", err)}
+		}
+		return BridgeReply{}, fmt.Errorf("QlCall, rpcClient call: %w", err)
 	}
-	peerInfo, err := peer.AddrInfoFromP2pAddr(addrs[0])
+	return reply, nil
+}
+
+// CallStream is the streaming variant of Call, for methods that answer
+// with a sequence of replies. The replies are sent on replies as they arrive,
+// which is closed at the end of the stream, and CallStream returns once it
+// is closed. A slow reader of replies holds back the peer.
+func (c *Client) CallStream(
+	ctx context.Context,
+	input interface{},
+	peerAddr string,
+	serviceName string,
+	serviceMethod string,
+	method string,
+	span trace.Span,
+	replies chan BridgeReply,
+) error {
+	body, err := json.Marshal(input)
 	if err != nil {
-		return BridgeReply{}, err
+		close(replies)
+		return fmt.Errorf("QlCallStream, json marshal input: %w", err)
 	}
 
-	err = h.Connect(ctx, *peerInfo)
+	id, err := c.connect(ctx, peerAddr)
 	if err != nil {
-		return BridgeReply{}, fmt.Errorf("QlCall, host connection: \n%w", err)
+		close(replies)
+		return err
 	}
-	rpcClient := rpc.NewClient(h, "/p2p/1.0.0")
 
+	args, err := bridgeArgs(ctx, method, body, span)
+	if err != nil {
+		close(replies)
+		return err
+	}
+	argsChan := make(chan BridgeArgs, 1)
+	argsChan <- args
+	close(argsChan)
+
+	err = c.v1.Stream(ctx, id, serviceName, serviceMethod, argsChan, replies)
+	if err != nil {
+		if rpc.IsClientError(err) {
+			c.forget(peerAddr)
+			return unreachableError{fmt.Errorf("QlCallStream, rpcClient stream: %w", err)}
+		}
+		return fmt.Errorf("QlCallStream, rpcClient stream: %w", err)
+	}
+	return nil
+}
+
+func bridgeArgs(ctx context.Context, method string, body []byte, span trace.Span) (BridgeArgs, error) {
 	bCall := BridgeCall{Method: method, Body: body}
 	if span != nil {
 		carrier := propagation.MapCarrier{}
@@ -63,20 +161,66 @@ func QlCall(
 
 	args, err := json.Marshal(bCall)
 	if err != nil {
-		return BridgeReply{}, fmt.Errorf("QlCall, json marshal BridgeCall: %w", err)
+		return BridgeArgs{}, fmt.Errorf("QlCall, json marshal BridgeCall: %w", err)
 	}
+	return BridgeArgs{Data: args}, nil
+}
 
-	var reply BridgeReply
+// resolve returns the peer behind peerAddr, resolving its DNS components
+// unless it was resolved recently. All the resolved addresses are kept and
+// libp2p dials whichever works.
+func (c *Client) resolve(ctx context.Context, peerAddr string) (peer.AddrInfo, error) {
+	c.mu.Lock()
+	cached, ok := c.addrs[peerAddr]
+	c.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.info, nil
+	}
 
-	err = rpcClient.Call(
-		peerInfo.ID,
-		serviceName,
-		serviceMethod,
-		BridgeArgs{Data: args},
-		&reply,
-	)
+	ma, err := multiaddr.NewMultiaddr(peerAddr)
 	if err != nil {
-		return BridgeReply{}, fmt.Errorf("QlCall, rpcClient call: %w", err)
+		return peer.AddrInfo{}, err
 	}
-	return reply, nil
+	addrs, err := madns.Resolve(ctx, ma)
+	if err != nil {
+		return peer.AddrInfo{}, fmt.Errorf("QlCall, DNS resolve: %w", err)
+	}
+	infos, err := peer.AddrInfosFromP2pAddrs(addrs...)
+	if err != nil {
+		return peer.AddrInfo{}, err
+	}
+	if len(infos) != 1 {
+		return peer.AddrInfo{}, fmt.Errorf("QlCall, %s resolves to %d peers", peerAddr, len(infos))
+	}
+
+	c.mu.Lock()
+	c.addrs[peerAddr] = cachedAddr{info: infos[0], expires: time.Now().Add(addrCacheTTL)}
+	c.mu.Unlock()
+	return infos[0], nil
+}
+
+// forget drops the cached resolution of peerAddr, for the next call to resolve it again.
+func (c *Client) forget(peerAddr string) {
+	c.mu.Lock()
+	delete(c.addrs, peerAddr)
+	c.mu.Unlock()
+}
+
+// connect makes sure the host is connected to the peer at peerAddr, reusing
+// the connection when there is one.
+func (c *Client) connect(ctx context.Context, peerAddr string) (peer.ID, error) {
+	info, err := c.resolve(ctx, peerAddr)
+	if err != nil {
+		return "", err
+	}
+	if c.h.Network().Connectedness(info.ID) == network.Connected {
+		return info.ID, nil
+	}
+
+	err = c.h.Connect(ctx, info)
+	if err != nil {
+		c.forget(peerAddr)
+		return "", unreachableError{fmt.Errorf("QlCall, host connection: \n%w", err)}
+	}
+	return info.ID, nil
 }
@@ -0,0 +1,38 @@
+package ql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+)
+
+func TestClientResolve(t *testing.T) {
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	c := NewClient(h)
+	ctx := context.Background()
+
+	addr := "/ip4/127.0.0.1/tcp/4001/p2p/" + h.ID().String()
+	info, err := c.resolve(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != h.ID() || len(info.Addrs) != 1 {
+		t.Errorf("resolved %v", info)
+	}
+	if _, ok := c.addrs[addr]; !ok {
+		t.Error("resolved address was not cached")
+	}
+	c.forget(addr)
+	if _, ok := c.addrs[addr]; ok {
+		t.Error("forgotten address is still cached")
+	}
+
+	if _, err := c.resolve(ctx, "/ip4/127.0.0.1/tcp/4001"); err == nil {
+		t.Error("resolved an address without peer id")
+	}
+}
@@ -0,0 +1,116 @@
+package ql
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Registration is the body of a PingService.Ping call. It is signed with the
+// peer's schnorr key to prove ownership of PubKey.
+//
+// Consents lists the hosting consents of the other users served by the peer,
+// see [VerifyConsent].
+type Registration struct {
+	PubKey    string        `json:"pubkey"`
+	PeerID    string        `json:"peer_id"`
+	Address   string        `json:"address"`
+	CreatedAt int64         `json:"created_at"`
+	Sig       string        `json:"sig"`
+	Consents  []nostr.Event `json:"consents,omitempty"`
+}
+
+func NewRegistration(pubKey string, address string) (*Registration, error) {
+	info, err := peer.AddrInfoFromString(address)
+	if err != nil {
+		return nil, fmt.Errorf("registration address: %w", err)
+	}
+	return &Registration{
+		PubKey:    pubKey,
+		PeerID:    info.ID.String(),
+		Address:   address,
+		CreatedAt: time.Now().Unix(),
+	}, nil
+}
+
+// Hash is what gets signed: sha256 of "<peer id>;<multiaddr>;<created_at>".
+func (r *Registration) Hash() [32]byte {
+	return sha256.Sum256([]byte(fmt.Sprintf("%s;%s;%d", r.PeerID, r.Address, r.CreatedAt)))
+}
+
+func (r *Registration) Sign(sk *btcec.PrivateKey) error {
+	h := r.Hash()
+	sig, err := schnorr.Sign(sk, h[:])
+	if err != nil {
+		return err
+	}
+	r.Sig = hex.EncodeToString(sig.Serialize())
+	return nil
+}
+
+// Verify checks the signature against PubKey and that the multiaddr points to PeerID.
+// Freshness and replay checks are left to the receiver.
+func (r *Registration) Verify() error {
+	if r.Sig == "" {
+		return errors.New("unsigned registration")
+	}
+
+	pk, err := hex.DecodeString(r.PubKey)
+	if err != nil {
+		return fmt.Errorf("pubkey is invalid hex: %w", err)
+	}
+	pubKey, err := schnorr.ParsePubKey(pk)
+	if err != nil {
+		return fmt.Errorf("pubkey is invalid: %w", err)
+	}
+
+	s, err := hex.DecodeString(r.Sig)
+	if err != nil {
+		return fmt.Errorf("signature is invalid hex: %w", err)
+	}
+	sig, err := schnorr.ParseSignature(s)
+	if err != nil {
+		return fmt.Errorf("failed to parse signature: %w", err)
+	}
+
+	h := r.Hash()
+	if !sig.Verify(h[:], pubKey) {
+		return errors.New("signature is invalid")
+	}
+
+	info, err := peer.AddrInfoFromString(r.Address)
+	if err != nil {
+		return fmt.Errorf("address is invalid: %w", err)
+	}
+	if info.ID.String() != r.PeerID {
+		return errors.New("address does not belong to the peer id")
+	}
+
+	return nil
+}
+
+// HostedPubKeys returns PubKey followed by every pubkey with a valid consent.
+// Invalid consents are reported in the error but do not drop the valid ones.
+func (r *Registration) HostedPubKeys() ([]string, error) {
+	pubKeys := []string{r.PubKey}
+	var errs []error
+	for i := range r.Consents {
+		pk, err := VerifyConsent(&r.Consents[i], r.PubKey)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		pubKeys = append(pubKeys, pk)
+	}
+	if len(errs) > 0 {
+		return pubKeys, fmt.Errorf("%d invalid consents, first: %w", len(errs), errs[0])
+	}
+	return pubKeys, nil
+}
@@ -0,0 +1,103 @@
+package ql
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/keys"
+)
+
+const testAddress = "/ip4/127.0.0.1/tcp/10880/p2p/16Uiu2HAmP44YB5WWWdYccDYRzByum6fWDma13csdVUcySzwPMqYx"
+
+func TestRegistrationVerify(t *testing.T) {
+	_, _, sk, pk, err := keys.GetKeys("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey := hex.EncodeToString(schnorr.SerializePubKey(pk))
+
+	reg, err := NewRegistration(pubKey, testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Verify(); err == nil {
+		t.Error("unsigned registration verified")
+	}
+
+	if err := reg.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Verify(); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	tampered := *reg
+	tampered.CreatedAt++
+	if err := tampered.Verify(); err == nil {
+		t.Error("registration with a changed timestamp verified")
+	}
+
+	_, _, _, otherPk, _ := keys.GetKeys("")
+	tampered = *reg
+	tampered.PubKey = hex.EncodeToString(schnorr.SerializePubKey(otherPk))
+	if err := tampered.Verify(); err == nil {
+		t.Error("registration claiming another pubkey verified")
+	}
+
+	tampered = *reg
+	tampered.PeerID = "16Uiu2HAm9PtjvN3jmDo8ma2jMsnj2fW2EAR6y2HJw2fJYdA3uxnS"
+	if err := tampered.Verify(); err == nil {
+		t.Error("registration with a mismatched peer id verified")
+	}
+}
+
+func TestRegistrationHostedPubKeys(t *testing.T) {
+	_, _, hostSk, hostPk, _ := keys.GetKeys("")
+	host := hex.EncodeToString(schnorr.SerializePubKey(hostPk))
+	reg, err := NewRegistration(host, testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userSk := nostr.GeneratePrivateKey()
+	user, _ := nostr.GetPublicKey(userSk)
+	consent := nostr.Event{
+		PubKey:    user,
+		CreatedAt: time.Now(),
+		Kind:      KindHostingConsent,
+		Tags:      nostr.Tags{{"p", host}},
+	}
+	consent.Sign(userSk)
+
+	notForHost := nostr.Event{
+		PubKey:    user,
+		CreatedAt: time.Now(),
+		Kind:      KindHostingConsent,
+		Tags:      nostr.Tags{{"p", user}},
+	}
+	notForHost.Sign(userSk)
+
+	expired := nostr.Event{
+		PubKey:    user,
+		CreatedAt: time.Now(),
+		Kind:      KindHostingConsent,
+		Tags:      nostr.Tags{{"p", host}, {"expiration", strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)}},
+	}
+	expired.Sign(userSk)
+
+	reg.Consents = []nostr.Event{consent, notForHost, expired}
+	reg.Sign(hostSk)
+
+	hosted, err := reg.HostedPubKeys()
+	if err == nil || !strings.HasPrefix(err.Error(), "2 invalid consents") {
+		t.Errorf("err = %v; want the consent for another peer and the expired one reported", err)
+	}
+	if len(hosted) != 2 || hosted[0] != host || hosted[1] != user {
+		t.Errorf("hosted = %v; want [%s %s]", hosted, host, user)
+	}
+}
@@ -0,0 +1,392 @@
+package ql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-gorpc"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Versions of the Ql protocol. Peers serve both of them, hubs prefer
+// ProtocolV2 and fall back to ProtocolV1 for peers that don't speak it.
+const (
+	ProtocolV1 = "/p2p/1.0.0"
+	ProtocolV2 = "/demedia/ql/2.0.0"
+)
+
+// ServiceName is the name of the service answering ProtocolV2 calls.
+const ServiceName = "QlService"
+
+// Methods of ServiceName, as listed in [Capabilities].
+const (
+	MethodCapabilities = "Capabilities"
+	MethodSaveEvent    = "SaveEvent"
+	MethodQueryEvents  = "QueryEvents"
+	MethodStreamEvents = "StreamEvents"
+	MethodDeleteEvent  = "DeleteEvent"
+	MethodCountEvents  = "CountEvents"
+	MethodSearchEvents = "SearchEvents"
+)
+
+// Meta is sent along every ProtocolV2 request.
+type Meta struct {
+	// trace context of the caller
+	Carrier propagation.MapCarrier
+	// pubkey the hub authenticated the client with (NIP-42), if any. Peers
+	// ignore it unless the caller is their hub.
+	Authed string
+}
+
+type CapabilitiesRequest struct {
+	Meta
+}
+
+// Capabilities describes what a peer supports.
+type Capabilities struct {
+	// major version of the Ql protocol
+	Version int
+	Methods []string
+	// NIPs the peer storage implements on top of NIP-01
+	NIPs []int
+}
+
+// Supports reports whether method is one of the peer methods.
+func (c Capabilities) Supports(method string) bool {
+	for _, m := range c.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+type SaveEventRequest struct {
+	Meta
+	Event nostr.Event
+}
+
+type SaveEventResponse struct {
+	Error *Error
+}
+
+type QueryEventsRequest struct {
+	Meta
+	Filter nostr.Filter
+}
+
+type QueryEventsResponse struct {
+	Events []nostr.Event
+	Error  *Error
+}
+
+// StreamEventsReply is one reply of a StreamEvents stream, carrying either an
+// event or, last, the error that ended the stream.
+type StreamEventsReply struct {
+	Event *nostr.Event
+	Error *Error
+}
+
+type DeleteEventRequest struct {
+	Meta
+	ID     string
+	PubKey string
+}
+
+type DeleteEventResponse struct {
+	Error *Error
+}
+
+type CountEventsRequest struct {
+	Meta
+	Filter nostr.Filter
+}
+
+type CountEventsResponse struct {
+	Count int64
+	Error *Error
+}
+
+// SearchEventsRequest is a NIP-50 query, filter only matching the events whose
+// content also matches Search.
+type SearchEventsRequest struct {
+	Meta
+	Filter nostr.Filter
+	Search string
+}
+
+type SearchEventsResponse struct {
+	// most relevant first
+	Results []storage.SearchResult
+	Error   *Error
+}
+
+// v1Capabilities is what peers that only speak ProtocolV1 support.
+var v1Capabilities = Capabilities{
+	Version: 1,
+	Methods: []string{MethodSaveEvent, MethodQueryEvents, MethodStreamEvents, MethodDeleteEvent},
+	NIPs:    []int{9},
+}
+
+func newMeta(ctx context.Context, span trace.Span) Meta {
+	meta := Meta{Authed: Authed(ctx)}
+	if span != nil {
+		meta.Carrier = propagation.MapCarrier{}
+		propagation.TraceContext{}.Inject(ctx, meta.Carrier)
+	}
+	return meta
+}
+
+// negotiate connects to the peer at peerAddr and picks the protocol to talk to
+// it, as far as the peerstore already knows the protocols of the peer.
+func (c *Client) negotiate(ctx context.Context, peerAddr string) (peer.ID, string, error) {
+	id, err := c.connect(ctx, peerAddr)
+	if err != nil {
+		return "", "", err
+	}
+	known, _ := c.h.Peerstore().GetProtocols(id)
+	if len(known) > 0 {
+		if v2, _ := c.h.Peerstore().SupportsProtocols(id, ProtocolV2); len(v2) == 0 {
+			return id, ProtocolV1, nil
+		}
+	}
+	return id, ProtocolV2, nil
+}
+
+// callV2 calls method of the peer on ProtocolV2. errV1 is returned when the
+// peer turns out not to support it.
+func (c *Client) callV2(ctx context.Context, id peer.ID, method string, args, reply any) error {
+	err := c.v2.CallContext(ctx, id, ServiceName, method, args, reply)
+	if err == nil {
+		return nil
+	}
+	if rpc.IsClientError(err) {
+		if strings.Contains(err.Error(), "protocols not supported") {
+			return errV1
+		}
+		return unreachableError{fmt.Errorf("QlCall %s: %w", method, err)}
+	}
+	return AsError(err)
+}
+
+var errV1 = errors.New("peer only supports " + ProtocolV1)
+
+// v1Error extracts the error the peer answered a ProtocolV1 call with.
+func v1Error(err error) error {
+	if err == nil || IsUnreachable(err) {
+		return err
+	}
+	if inner := errors.Unwrap(err); inner != nil {
+		return AsError(inner)
+	}
+	return AsError(err)
+}
+
+// GetCapabilities asks the peer at peerAddr what it supports.
+func (c *Client) GetCapabilities(ctx context.Context, peerAddr string) (caps Capabilities, err error) {
+	defer observe(ctx, peerAddr, MethodCapabilities, time.Now(), &err)
+	id, proto, err := c.negotiate(ctx, peerAddr)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	if proto == ProtocolV2 {
+		var caps Capabilities
+		err := c.callV2(ctx, id, MethodCapabilities, CapabilitiesRequest{}, &caps)
+		if err != errV1 {
+			return caps, err
+		}
+	}
+	return v1Capabilities, nil
+}
+
+// SaveEvent stores evt on the peer at peerAddr. Errors answered by the peer are
+// of type *Error.
+func (c *Client) SaveEvent(ctx context.Context, peerAddr string, evt *nostr.Event, span trace.Span) (err error) {
+	defer observe(ctx, peerAddr, MethodSaveEvent, time.Now(), &err)
+	id, proto, err := c.negotiate(ctx, peerAddr)
+	if err != nil {
+		return err
+	}
+	if proto == ProtocolV2 {
+		var resp SaveEventResponse
+		err := c.callV2(ctx, id, MethodSaveEvent, SaveEventRequest{Meta: newMeta(ctx, span), Event: *evt}, &resp)
+		if err != errV1 {
+			if err == nil && resp.Error != nil {
+				return resp.Error
+			}
+			return err
+		}
+	}
+	_, err = c.Call(ctx, evt, peerAddr, "BridgeService", "Ql", "saveEvent", span)
+	return v1Error(err)
+}
+
+// QueryEvents returns the events of the peer at peerAddr matching filter.
+func (c *Client) QueryEvents(ctx context.Context, peerAddr string, filter *nostr.Filter, span trace.Span) (events []nostr.Event, err error) {
+	defer observe(ctx, peerAddr, MethodQueryEvents, time.Now(), &err)
+	id, proto, err := c.negotiate(ctx, peerAddr)
+	if err != nil {
+		return nil, err
+	}
+	if proto == ProtocolV2 {
+		var resp QueryEventsResponse
+		err := c.callV2(ctx, id, MethodQueryEvents, QueryEventsRequest{Meta: newMeta(ctx, span), Filter: *filter}, &resp)
+		if err != errV1 {
+			if err == nil && resp.Error != nil {
+				return nil, resp.Error
+			}
+			return resp.Events, err
+		}
+	}
+	reply, err := c.Call(ctx, filter, peerAddr, "BridgeService", "Ql", "queryEvents", span)
+	if err != nil {
+		return nil, v1Error(err)
+	}
+	if err := json.Unmarshal(reply.Data, &events); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reply data: %w", err)
+	}
+	return events, nil
+}
+
+// StreamEvents sends the events of the peer at peerAddr matching filter on
+// events as they arrive, and closes it at the end of the stream.
+func (c *Client) StreamEvents(ctx context.Context, peerAddr string, filter *nostr.Filter, span trace.Span, events chan<- nostr.Event) (err error) {
+	defer observe(ctx, peerAddr, MethodStreamEvents, time.Now(), &err)
+	id, proto, err := c.negotiate(ctx, peerAddr)
+	if err != nil {
+		close(events)
+		return err
+	}
+
+	if proto == ProtocolV2 {
+		args := make(chan QueryEventsRequest, 1)
+		args <- QueryEventsRequest{Meta: newMeta(ctx, span), Filter: *filter}
+		close(args)
+		replies := make(chan StreamEventsReply)
+		done := make(chan error, 1)
+		go func() {
+			done <- c.v2.Stream(ctx, id, ServiceName, MethodStreamEvents, args, replies)
+		}()
+
+		var peerErr error
+		for reply := range replies {
+			if reply.Error != nil {
+				peerErr = reply.Error
+			} else if reply.Event != nil {
+				events <- *reply.Event
+			}
+		}
+		err := <-done
+		switch {
+		case err == nil:
+			close(events)
+			return peerErr
+		case rpc.IsClientError(err) && strings.Contains(err.Error(), "protocols not supported"):
+			// fall back to ProtocolV1 below
+		case rpc.IsClientError(err):
+			close(events)
+			return unreachableError{fmt.Errorf("QlCall %s: %w", MethodStreamEvents, err)}
+		default:
+			close(events)
+			return AsError(err)
+		}
+	}
+
+	replies := make(chan BridgeReply)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.CallStream(ctx, filter, peerAddr, "BridgeService", "QlStream", "queryEvents", span, replies)
+	}()
+	var uerr error
+	for reply := range replies {
+		var evt nostr.Event
+		if err := json.Unmarshal(reply.Data, &evt); err != nil {
+			uerr = fmt.Errorf("failed to unmarshal reply data: %w", err)
+			continue
+		}
+		events <- evt
+	}
+	close(events)
+	if err := <-done; err != nil {
+		return v1Error(err)
+	}
+	return uerr
+}
+
+// DeleteEvent deletes the event id of pubkey from the peer at peerAddr.
+func (c *Client) DeleteEvent(ctx context.Context, peerAddr string, id string, pubkey string, span trace.Span) (err error) {
+	defer observe(ctx, peerAddr, MethodDeleteEvent, time.Now(), &err)
+	peerID, proto, err := c.negotiate(ctx, peerAddr)
+	if err != nil {
+		return err
+	}
+	if proto == ProtocolV2 {
+		var resp DeleteEventResponse
+		err := c.callV2(ctx, peerID, MethodDeleteEvent, DeleteEventRequest{Meta: newMeta(ctx, span), ID: id, PubKey: pubkey}, &resp)
+		if err != errV1 {
+			if err == nil && resp.Error != nil {
+				return resp.Error
+			}
+			return err
+		}
+	}
+	_, err = c.Call(ctx, nostr.Event{ID: id, PubKey: pubkey}, peerAddr, "BridgeService", "Ql", "deleteEvent", span)
+	return v1Error(err)
+}
+
+// CountEvents counts the events of the peer at peerAddr matching filter, see
+// NIP-45. Peers whose storage can't count answer with CodeUnknownMethod.
+func (c *Client) CountEvents(ctx context.Context, peerAddr string, filter *nostr.Filter, span trace.Span) (count int64, err error) {
+	defer observe(ctx, peerAddr, MethodCountEvents, time.Now(), &err)
+	id, proto, err := c.negotiate(ctx, peerAddr)
+	if err != nil {
+		return 0, err
+	}
+	if proto == ProtocolV2 {
+		var resp CountEventsResponse
+		err := c.callV2(ctx, id, MethodCountEvents, CountEventsRequest{Meta: newMeta(ctx, span), Filter: *filter}, &resp)
+		if err != errV1 {
+			if err == nil && resp.Error != nil {
+				return 0, resp.Error
+			}
+			return resp.Count, err
+		}
+	}
+	reply, err := c.Call(ctx, filter, peerAddr, "BridgeService", "Ql", "countEvents", span)
+	if err != nil {
+		return 0, v1Error(err)
+	}
+	if err := json.Unmarshal(reply.Data, &count); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal reply data: %w", err)
+	}
+	return count, nil
+}
+
+// SearchEvents runs a full-text search (NIP-50) on the peer at peerAddr. It
+// needs ProtocolV2, peers that can't search answer with CodeUnknownMethod.
+func (c *Client) SearchEvents(ctx context.Context, peerAddr string, filter *nostr.Filter, search string, span trace.Span) (results []storage.SearchResult, err error) {
+	defer observe(ctx, peerAddr, MethodSearchEvents, time.Now(), &err)
+	id, proto, err := c.negotiate(ctx, peerAddr)
+	if err != nil {
+		return nil, err
+	}
+	if proto == ProtocolV2 {
+		var resp SearchEventsResponse
+		err := c.callV2(ctx, id, MethodSearchEvents, SearchEventsRequest{Meta: newMeta(ctx, span), Filter: *filter, Search: search}, &resp)
+		if err != errV1 {
+			if err == nil && resp.Error != nil {
+				return nil, resp.Error
+			}
+			return resp.Results, err
+		}
+	}
+	return nil, NewError(CodeUnknownMethod, "peer %s doesn't support search", peerAddr)
+}
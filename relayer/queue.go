@@ -0,0 +1,42 @@
+package relayer
+
+import (
+	"context"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// enqueue queues message to be handled after the previous ones of ws. When
+// the queue is full, the client is sending faster than it is served: it is
+// disconnected and enqueue returns false.
+func (s *Server) enqueue(ctx context.Context, ws *WebSocket, queue chan<- []byte, message []byte) bool {
+	select {
+	case queue <- message:
+		return true
+	default:
+		s.Log.WarningfWithContext(ctx, "dropping a connection with %d messages waiting", len(queue))
+		ws.mutex.Lock()
+		ws.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "rate-limited: too many messages waiting"),
+			time.Now().Add(time.Second))
+		ws.mutex.Unlock()
+		return false
+	}
+}
+
+// process handles the messages of queue in order, each one once a worker is
+// free, until queue is closed. Messages left when ctx is done are dropped.
+func (s *Server) process(ctx context.Context, queue <-chan []byte, handle func(context.Context, []byte)) {
+	for message := range queue {
+		select {
+		case s.workers <- struct{}{}:
+		case <-ctx.Done():
+			continue
+		}
+		if ctx.Err() == nil {
+			handle(ctx, message)
+		}
+		<-s.workers
+	}
+}
@@ -0,0 +1,82 @@
+package relayer
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestMessagesInOrder(t *testing.T) {
+	srv := startTestRelay(t, &testRelay{storage: &testStorage{}})
+	defer srv.Shutdown(context.Background())
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+srv.Addr(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const n = 20
+	for i := 0; i < n; i++ {
+		if err := conn.WriteJSON([]interface{}{"REQ", strconv.Itoa(i), nostr.Filter{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for i := 0; i < n; i++ {
+		var reply []json.RawMessage
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatal(err)
+		}
+		var id string
+		json.Unmarshal(reply[1], &id)
+		if id != strconv.Itoa(i) {
+			t.Fatalf("got %s, want the EOSE of %d", reply, i)
+		}
+	}
+}
+
+func TestQueueOverflow(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	store := &testStorage{queryEvents: func(*nostr.Filter) ([]nostr.Event, error) {
+		<-release
+		return nil, nil
+	}}
+	srv := startTestRelay(t, &testRelay{storage: store}, WithQueueSize(2), WithWorkers(1))
+	defer srv.Shutdown(context.Background())
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+srv.Addr(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the first REQ blocks the worker, the next two fill the queue
+	for i := 0; i < 4; i++ {
+		if err := conn.WriteJSON([]interface{}{"REQ", strconv.Itoa(i), nostr.Filter{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var reply []json.RawMessage
+	err = conn.ReadJSON(&reply)
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("got %s, %v, want the connection closed", reply, err)
+	}
+}
+
+func TestWithWorkersDefault(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		s := &Server{}
+		WithWorkers(n)(s)
+		if cap(s.workers) != DefaultWorkers {
+			t.Errorf("WithWorkers(%d) gives %d workers; want %d", n, cap(s.workers), DefaultWorkers)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package relayer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/time/rate"
+)
+
+// Rate is a token bucket: Burst messages at once, refilled at PerSecond.
+// The zero Rate is unlimited.
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// Decode reads a Rate from the environment, as "<per second>,<burst>".
+func (r *Rate) Decode(value string) error {
+	perSecond, burst, ok := strings.Cut(value, ",")
+	if !ok {
+		return fmt.Errorf("invalid rate %q, want <per second>,<burst>", value)
+	}
+	var err error
+	if r.PerSecond, err = strconv.ParseFloat(perSecond, 64); err != nil {
+		return fmt.Errorf("invalid rate %q: %w", value, err)
+	}
+	if r.Burst, err = strconv.Atoi(burst); err != nil {
+		return fmt.Errorf("invalid rate %q: %w", value, err)
+	}
+	return nil
+}
+
+func (r Rate) limiter() *rate.Limiter {
+	if r.PerSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(r.PerSecond), r.Burst)
+}
+
+// RateLimits are the budgets of the websocket clients of a [Server]. Zero
+// values are unlimited.
+type RateLimits struct {
+	// EVENT messages
+	EventsPerIP         Rate `envconfig:"EVENTS_PER_IP" default:"10,50"`
+	EventsPerConnection Rate `envconfig:"EVENTS_PER_CONNECTION" default:"5,20"`
+	EventsPerPubKey     Rate `envconfig:"EVENTS_PER_PUBKEY" default:"2,20"`
+
+	// REQ and COUNT messages
+	ReqsPerIP         Rate `envconfig:"REQS_PER_IP" default:"20,100"`
+	ReqsPerConnection Rate `envconfig:"REQS_PER_CONNECTION" default:"10,50"`
+
+	// open subscriptions
+	SubscriptionsPerIP         int `envconfig:"SUBSCRIPTIONS_PER_IP" default:"100"`
+	SubscriptionsPerConnection int `envconfig:"SUBSCRIPTIONS_PER_CONNECTION" default:"20"`
+
+	// ForwardedFor keys clients by the first X-Forwarded-For address instead
+	// of the remote one, only set it behind a reverse proxy.
+	ForwardedFor bool `envconfig:"FORWARDED_FOR" default:"false"`
+}
+
+// RateLimiter is implemented by relays that limit what their websocket clients
+// send, see [RateLimits]. Violations are answered with a "rate-limited" OK or
+// NOTICE.
+type RateLimiter interface {
+	RateLimits() RateLimits
+}
+
+// how long the budget of an IP or a pubkey is kept once it is idle
+const budgetIdleTTL = 10 * time.Minute
+
+type budget struct {
+	events, reqs *rate.Limiter
+	lastSeen     time.Time
+}
+
+// limiter enforces the RateLimits of a Server. Each connection gets its own
+// budgets, which are shared with the other connections of its IP and, for
+// events, with the other events of the same pubkey.
+type limiter struct {
+	limits RateLimits
+
+	mu       sync.Mutex
+	byIP     map[string]*budget
+	byPubKey map[string]*budget
+	swept    time.Time
+
+	rejected metric.Int64Counter
+}
+
+func newLimiter(limits RateLimits) *limiter {
+	l := &limiter{
+		limits:   limits,
+		byIP:     make(map[string]*budget),
+		byPubKey: make(map[string]*budget),
+		swept:    time.Now(),
+	}
+
+	l.rejected, _ = meter.Int64Counter("relayer.ratelimit.rejected",
+		metric.WithDescription("Messages rejected for exceeding a rate limit, by budget and key"))
+	meter.Float64ObservableGauge("relayer.ratelimit.limit",
+		metric.WithDescription("Configured rate limits, in messages per second or open subscriptions"),
+		metric.WithFloat64Callback(func(_ context.Context, o metric.Float64Observer) error {
+			observe := func(budget, key string, v float64) {
+				o.Observe(v, metric.WithAttributes(attribute.String("budget", budget), attribute.String("key", key)))
+			}
+			observe("event", "ip", limits.EventsPerIP.PerSecond)
+			observe("event", "connection", limits.EventsPerConnection.PerSecond)
+			observe("event", "pubkey", limits.EventsPerPubKey.PerSecond)
+			observe("req", "ip", limits.ReqsPerIP.PerSecond)
+			observe("req", "connection", limits.ReqsPerConnection.PerSecond)
+			observe("subscription", "ip", float64(limits.SubscriptionsPerIP))
+			observe("subscription", "connection", float64(limits.SubscriptionsPerConnection))
+			return nil
+		}))
+	return l
+}
+
+// clientIP returns the address r is rate limited by.
+func (l *limiter) clientIP(r *http.Request) string {
+	if l.limits.ForwardedFor {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			ip, _, _ := strings.Cut(fwd, ",")
+			return strings.TrimSpace(ip)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// connection returns the budgets of a new connection.
+func (l *limiter) connection() *budget {
+	return &budget{
+		events: l.limits.EventsPerConnection.limiter(),
+		reqs:   l.limits.ReqsPerConnection.limiter(),
+	}
+}
+
+func (l *limiter) get(m map[string]*budget, key string, events, reqs Rate) *budget {
+	now := time.Now()
+	if now.Sub(l.swept) > budgetIdleTTL {
+		l.sweep(now)
+	}
+	b, ok := m[key]
+	if !ok {
+		b = &budget{events: events.limiter(), reqs: reqs.limiter()}
+		m[key] = b
+	}
+	b.lastSeen = now
+	return b
+}
+
+// sweep forgets the budgets that have been idle for long, they are full again
+// anyway.
+func (l *limiter) sweep(now time.Time) {
+	for _, m := range []map[string]*budget{l.byIP, l.byPubKey} {
+		for key, b := range m {
+			if now.Sub(b.lastSeen) > budgetIdleTTL {
+				delete(m, key)
+			}
+		}
+	}
+	l.swept = now
+}
+
+func allow(lim *rate.Limiter) bool {
+	return lim == nil || lim.Allow()
+}
+
+// allowEvent reports whether ws may send an EVENT, or the message it is
+// rejected with.
+func (l *limiter) allowEvent(ctx context.Context, ws *WebSocket) (bool, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case !allow(ws.budget.events):
+		return l.reject(ctx, "event", "connection", "rate-limited: slow down, too many events on this connection")
+	case !allow(l.get(l.byIP, ws.ip, l.limits.EventsPerIP, l.limits.ReqsPerIP).events):
+		return l.reject(ctx, "event", "ip", "rate-limited: slow down, too many events from this address")
+	}
+	return true, ""
+}
+
+// allowPubKey reports whether an EVENT of pubkey may be handled, or the
+// message it is rejected with. The signature of the event must have been
+// checked, so that nobody spends the budget of somebody else.
+func (l *limiter) allowPubKey(ctx context.Context, pubkey string) (bool, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !allow(l.get(l.byPubKey, pubkey, l.limits.EventsPerPubKey, Rate{}).events) {
+		return l.reject(ctx, "event", "pubkey", "rate-limited: slow down, too many events of this pubkey")
+	}
+	return true, ""
+}
+
+// allowReq reports whether ws may send a REQ or a COUNT, or the message it is
+// rejected with.
+func (l *limiter) allowReq(ctx context.Context, ws *WebSocket) (bool, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case !allow(ws.budget.reqs):
+		return l.reject(ctx, "req", "connection", "rate-limited: slow down, too many requests on this connection")
+	case !allow(l.get(l.byIP, ws.ip, l.limits.EventsPerIP, l.limits.ReqsPerIP).reqs):
+		return l.reject(ctx, "req", "ip", "rate-limited: slow down, too many requests from this address")
+	}
+	return true, ""
+}
+
+// allowSubscription reports whether ws may open the subscription id, or the
+// message it is rejected with. Replacing an open subscription is always
+// allowed.
+func (l *limiter) allowSubscription(ctx context.Context, ws *WebSocket, id string) (bool, string) {
+	perConn, perIP := l.limits.SubscriptionsPerConnection, l.limits.SubscriptionsPerIP
+	if perConn <= 0 && perIP <= 0 {
+		return true, ""
+	}
+
+	listenersMutex.Lock()
+	if _, ok := listeners[ws][id]; ok {
+		listenersMutex.Unlock()
+		return true, ""
+	}
+	connSubs := len(listeners[ws])
+	ipSubs := 0
+	for other, subs := range listeners {
+		if other.ip == ws.ip {
+			ipSubs += len(subs)
+		}
+	}
+	listenersMutex.Unlock()
+
+	switch {
+	case perConn > 0 && connSubs >= perConn:
+		return l.reject(ctx, "subscription", "connection", fmt.Sprintf("rate-limited: no more than %d open subscriptions per connection", perConn))
+	case perIP > 0 && ipSubs >= perIP:
+		return l.reject(ctx, "subscription", "ip", fmt.Sprintf("rate-limited: no more than %d open subscriptions per address", perIP))
+	}
+	return true, ""
+}
+
+func (l *limiter) reject(ctx context.Context, budget, key, message string) (bool, string) {
+	l.rejected.Add(ctx, 1, metric.WithAttributes(attribute.String("budget", budget), attribute.String("key", key)))
+	return false, message
+}
@@ -0,0 +1,112 @@
+package relayer
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/storage/memory"
+)
+
+func TestRateLimits(t *testing.T) {
+	srv := startTestRelay(t, &testRelay{
+		storage: &memory.MemoryBackend{},
+		rateLimits: RateLimits{
+			ReqsPerConnection:          Rate{PerSecond: 0.001, Burst: 3},
+			SubscriptionsPerConnection: 2,
+		},
+	})
+	defer srv.Shutdown(context.Background())
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+srv.Addr(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	read := func() (string, string) {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var reply []json.RawMessage
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatal(err)
+		}
+		var typ, arg string
+		json.Unmarshal(reply[0], &typ)
+		json.Unmarshal(reply[1], &arg)
+		return typ, arg
+	}
+
+	for i, want := range []string{"EOSE", "EOSE", "rate-limited: no more than 2", "rate-limited: slow down"} {
+		if err := conn.WriteJSON([]interface{}{"REQ", string(rune('a' + i)), nostr.Filter{Kinds: []int{1}}}); err != nil {
+			t.Fatal(err)
+		}
+		typ, arg := read()
+		if typ == "NOTICE" {
+			typ = arg
+		}
+		if !strings.HasPrefix(typ, want) {
+			t.Errorf("REQ %d: got %s %s, want %s", i, typ, arg, want)
+		}
+	}
+}
+
+func TestRateDecode(t *testing.T) {
+	t.Setenv("RATE_LIMIT_EVENTS_PER_IP", "0.5,10")
+	var config struct {
+		Limits RateLimits `envconfig:"RATE_LIMIT"`
+	}
+	if err := envconfig.Process("", &config); err != nil {
+		t.Fatal(err)
+	}
+	if got := config.Limits.EventsPerIP; got != (Rate{PerSecond: 0.5, Burst: 10}) {
+		t.Errorf("got %+v", got)
+	}
+	if got := config.Limits.SubscriptionsPerConnection; got != 20 {
+		t.Errorf("got %d subscriptions per connection, want the default", got)
+	}
+}
+
+func TestRateLimitsUnsignedDeletions(t *testing.T) {
+	srv := startTestRelay(t, &testRelay{
+		storage:    &memory.MemoryBackend{},
+		rateLimits: RateLimits{EventsPerPubKey: Rate{PerSecond: 0.001, Burst: 1}},
+	})
+	defer srv.Shutdown(context.Background())
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+srv.Addr(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	// deletions are answered with nothing, signed or not
+	for i := 0; i < 3; i++ {
+		forged := nostr.Event{PubKey: pk, Kind: 5, CreatedAt: time.Now(), Sig: strings.Repeat("0", 128)}
+		if err := conn.WriteJSON([]interface{}{"EVENT", forged}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	evt := nostr.Event{PubKey: pk, Kind: 1, CreatedAt: time.Now()}
+	if err := evt.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteJSON([]interface{}{"EVENT", evt}); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var reply []interface{}
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if len(reply) != 4 || reply[2] != true {
+		t.Errorf("got %v, want the signed event accepted", reply)
+	}
+}
@@ -0,0 +1,217 @@
+package registry
+
+import (
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is how long a peer stays routable after its last ping.
+const DefaultTTL = 5 * time.Second
+
+type Peer struct {
+	PubKey     string
+	Address    string
+	LastUpdate time.Time
+}
+
+// Store persists registry entries so that they survive hub restarts.
+type Store interface {
+	LoadPeers() ([]Peer, error)
+	SavePeer(peer Peer) error
+	// DeletePeer deletes the peer unless it was saved after before, as it
+	// may have pinged again since it expired.
+	DeletePeer(pubkey string, address string, before time.Time) error
+}
+
+// Registry is a concurrency-safe cache of the peers registered with the hub,
+// optionally backed by a persistent Store.
+// A pubkey may be served by several peers, each one is tracked separately.
+type Registry struct {
+	mu    sync.RWMutex
+	peers map[string]map[string]Peer // pubkey -> address -> peer
+	store Store
+	ttl   time.Duration
+}
+
+// New creates a registry. A nil store keeps the peers in memory only and
+// a zero ttl falls back to DefaultTTL.
+func New(store Store, ttl time.Duration) *Registry {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Registry{
+		peers: make(map[string]map[string]Peer),
+		store: store,
+		ttl:   ttl,
+	}
+}
+
+// Load fills the cache from the store. Restored peers get a fresh TTL so they
+// have a chance to ping again before they are expired.
+func (r *Registry) Load() error {
+	if r.store == nil {
+		return nil
+	}
+	peers, err := r.store.LoadPeers()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, p := range peers {
+		p.LastUpdate = now
+		r.set(p)
+	}
+	return nil
+}
+
+func (r *Registry) set(p Peer) {
+	byAddr, ok := r.peers[p.PubKey]
+	if !ok {
+		byAddr = make(map[string]Peer)
+		r.peers[p.PubKey] = byAddr
+	}
+	byAddr[p.Address] = p
+}
+
+// Save registers address as a peer serving pubkey.
+func (r *Registry) Save(address string, pubkey string) error {
+	p := Peer{
+		PubKey:     pubkey,
+		Address:    address,
+		LastUpdate: time.Now(),
+	}
+
+	r.mu.Lock()
+	r.set(p)
+	r.mu.Unlock()
+
+	if r.store != nil {
+		return r.store.SavePeer(p)
+	}
+	return nil
+}
+
+// Get returns the address of the peer serving pubkey that pinged last. If there
+// is none, a random live peer is picked, and "" is returned when no peer is registered.
+func (r *Registry) Get(pubkey string) string {
+	if addrs := r.Lookup(pubkey, 1); len(addrs) > 0 {
+		return addrs[0]
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.random()
+}
+
+// Lookup returns up to n addresses of the peers serving pubkey, the ones that
+// pinged last first. Unlike Get, it doesn't fall back to other peers.
+func (r *Registry) Lookup(pubkey string, n int) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	byAddr := r.peers[pubkey]
+	if len(byAddr) == 0 {
+		return nil
+	}
+
+	peers := make([]Peer, 0, len(byAddr))
+	for _, p := range byAddr {
+		peers = append(peers, p)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].LastUpdate.After(peers[j].LastUpdate)
+	})
+	if n > 0 && len(peers) > n {
+		peers = peers[:n]
+	}
+	addrs := make([]string, len(peers))
+	for i, p := range peers {
+		addrs[i] = p.Address
+	}
+	return addrs
+}
+
+func (r *Registry) random() string {
+	if len(r.peers) == 0 {
+		return ""
+	}
+
+	n := rand.Intn(len(r.peers))
+	i := 0
+	for _, byAddr := range r.peers {
+		if i == n {
+			for _, p := range byAddr {
+				return p.Address
+			}
+		}
+		i++
+	}
+	return ""
+}
+
+// Addresses returns the distinct addresses of every live peer.
+func (r *Registry) Addresses() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	seen := make(map[string]struct{})
+	var addrs []string
+	for _, byAddr := range r.peers {
+		for addr := range byAddr {
+			if _, ok := seen[addr]; ok {
+				continue
+			}
+			seen[addr] = struct{}{}
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// Expire removes every peer that did not ping within the TTL and returns them.
+func (r *Registry) Expire(now time.Time) []Peer {
+	r.mu.Lock()
+	var expired []Peer
+	for k, byAddr := range r.peers {
+		for addr, p := range byAddr {
+			if now.Sub(p.LastUpdate) > r.ttl {
+				delete(byAddr, addr)
+				expired = append(expired, p)
+			}
+		}
+		if len(byAddr) == 0 {
+			delete(r.peers, k)
+		}
+	}
+	r.mu.Unlock()
+
+	if r.store != nil {
+		cutoff := now.Add(-r.ttl)
+		for _, p := range expired {
+			if err := r.store.DeletePeer(p.PubKey, p.Address, cutoff); err != nil {
+				log.Printf("failed to delete expired peer %s at %s: %v", p.PubKey, p.Address, err)
+			}
+		}
+	}
+	return expired
+}
+
+// Snapshot returns a copy of the live peers keyed by pubkey.
+func (r *Registry) Snapshot() map[string][]Peer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m := make(map[string][]Peer, len(r.peers))
+	for k, byAddr := range r.peers {
+		for _, p := range byAddr {
+			m[k] = append(m[k], p)
+		}
+	}
+	return m
+}
@@ -0,0 +1,139 @@
+package registry
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type testStore struct {
+	mu    sync.Mutex
+	peers map[string]Peer // keyed by pubkey + address
+}
+
+func (s *testStore) LoadPeers() ([]Peer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var peers []Peer
+	for _, p := range s.peers {
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
+
+func (s *testStore) SavePeer(p Peer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peers[p.PubKey+p.Address] = p
+	return nil
+}
+
+func (s *testStore) DeletePeer(pubkey string, address string, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.peers[pubkey+address]; ok && !p.LastUpdate.After(before) {
+		delete(s.peers, pubkey+address)
+	}
+	return nil
+}
+
+func TestRegistryExpire(t *testing.T) {
+	store := &testStore{peers: map[string]Peer{}}
+	r := New(store, time.Second)
+	r.Save("/ip4/127.0.0.1/tcp/1", "a")
+	r.Save("/ip4/127.0.0.1/tcp/2", "b")
+
+	if got := r.Get("a"); got != "/ip4/127.0.0.1/tcp/1" {
+		t.Errorf("Get(a) = %q", got)
+	}
+	if got := r.Get("unknown"); got == "" {
+		t.Error("Get(unknown) should fall back to a live peer")
+	}
+
+	expired := r.Expire(time.Now().Add(2 * time.Second))
+	if len(expired) != 2 {
+		t.Errorf("expired %d peers; want 2", len(expired))
+	}
+	if got := r.Get("a"); got != "" {
+		t.Errorf("Get(a) after expiry = %q; want empty", got)
+	}
+	if len(store.peers) != 0 {
+		t.Errorf("store has %d peers after expiry; want 0", len(store.peers))
+	}
+}
+
+func TestRegistryExpireKeepsSavedAgain(t *testing.T) {
+	store := &testStore{peers: map[string]Peer{}}
+	r := New(store, time.Second)
+	r.Save("/ip4/127.0.0.1/tcp/1", "a")
+
+	// the peer pings again while it is being expired
+	now := time.Now().Add(2 * time.Second)
+	store.SavePeer(Peer{PubKey: "a", Address: "/ip4/127.0.0.1/tcp/1", LastUpdate: now})
+	if expired := r.Expire(now); len(expired) != 1 {
+		t.Fatalf("expired %d peers; want 1", len(expired))
+	}
+	if len(store.peers) != 1 {
+		t.Error("Expire deleted a peer saved after it expired")
+	}
+}
+
+func TestRegistryLoad(t *testing.T) {
+	store := &testStore{peers: map[string]Peer{
+		"a/ip4/127.0.0.1/tcp/1": {PubKey: "a", Address: "/ip4/127.0.0.1/tcp/1", LastUpdate: time.Now().Add(-time.Hour)},
+	}}
+	r := New(store, time.Second)
+	if err := r.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	// restored peers get a fresh ttl
+	if expired := r.Expire(time.Now()); len(expired) != 0 {
+		t.Errorf("expired %v right after load", expired)
+	}
+	if got := r.Get("a"); got != "/ip4/127.0.0.1/tcp/1" {
+		t.Errorf("Get(a) = %q", got)
+	}
+}
+
+func TestRegistryConcurrent(t *testing.T) {
+	r := New(nil, time.Second)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.Save("addr", "a")
+				r.Get("a")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.Expire(time.Now())
+				r.Snapshot()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRegistryLookup(t *testing.T) {
+	r := New(nil, time.Second)
+	r.Save("/ip4/127.0.0.1/tcp/1", "a")
+	time.Sleep(time.Millisecond)
+	r.Save("/ip4/127.0.0.1/tcp/2", "a")
+	r.Save("/ip4/127.0.0.1/tcp/3", "b")
+
+	addrs := r.Lookup("a", 5)
+	if len(addrs) != 2 || addrs[0] != "/ip4/127.0.0.1/tcp/2" {
+		t.Errorf("Lookup(a, 5) = %v; want both peers of a, last ping first", addrs)
+	}
+	if addrs := r.Lookup("a", 1); len(addrs) != 1 {
+		t.Errorf("Lookup(a, 1) = %v; want one peer", addrs)
+	}
+	if addrs := r.Lookup("unknown", 5); len(addrs) != 0 {
+		t.Errorf("Lookup(unknown, 5) = %v; want none", addrs)
+	}
+}
@@ -0,0 +1,98 @@
+package relayer
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/ql"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// peerTimeouts bound the calls to the peers, see [WithPeerTimeouts].
+type peerTimeouts struct {
+	call, query time.Duration
+}
+
+type peerTimeoutsKey struct{}
+
+// withPeerTimeouts returns a copy of ctx carrying t to the peer calls.
+func withPeerTimeouts(ctx context.Context, t peerTimeouts) context.Context {
+	return context.WithValue(ctx, peerTimeoutsKey{}, t)
+}
+
+// peerTimeoutsOf returns the timeouts carried by ctx, or the defaults.
+func peerTimeoutsOf(ctx context.Context) peerTimeouts {
+	var t peerTimeouts
+	if ctx != nil {
+		t, _ = ctx.Value(peerTimeoutsKey{}).(peerTimeouts)
+	}
+	if t.call <= 0 {
+		t.call = DefaultPeerCallTimeout
+	}
+	if t.query <= 0 {
+		t.query = DefaultPeerQueryTimeout
+	}
+	return t
+}
+
+// peerContext returns the context of a call to the peers. It keeps the span,
+// the timeouts and the authenticated pubkey of ctx but not its cancellation, as
+// the websocket request context is done long before the messages of the
+// connection are handled.
+func peerContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	t := peerTimeoutsOf(ctx)
+	pctx := trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+	pctx = ql.WithAuthed(pctx, ql.Authed(ctx))
+	pctx = withPeerTimeouts(pctx, t)
+	return context.WithTimeout(pctx, t.call)
+}
+
+// replicas returns the addresses of the peers holding pubkey's events and the
+// number of them that must acknowledge a write. Only the peers registered for
+// pubkey are returned, as the others haven't consented to host its events.
+func replicas(relay Relay, pubkey string) (addresses []string, quorum int) {
+	store := relay.Storage()
+	rep, ok := relay.(Replicator)
+	lister, isLister := store.(PeerLister)
+	if !ok || !isLister {
+		if address := store.GetPeer(pubkey); address != "" {
+			return []string{address}, 1
+		}
+		return nil, 1
+	}
+
+	n := rep.ReplicationFactor()
+	if n < 1 {
+		n = 1
+	}
+	quorum = rep.WriteQuorum()
+	if quorum < 1 {
+		quorum = 1
+	}
+	if quorum > n {
+		quorum = n
+	}
+	return lister.GetPeers(pubkey, n), quorum
+}
+
+// mergeEvents merges the results of several replicas, dropping duplicated IDs
+// and sorting them by created_at DESC.
+func mergeEvents(results ...[]nostr.Event) []nostr.Event {
+	seen := make(map[string]struct{})
+	var events []nostr.Event
+	for _, evts := range results {
+		for _, evt := range evts {
+			if _, ok := seen[evt.ID]; ok {
+				continue
+			}
+			seen[evt.ID] = struct{}{}
+			events = append(events, evt)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].CreatedAt.After(events[j].CreatedAt)
+	})
+	return events
+}
@@ -0,0 +1,171 @@
+package relayer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/ql"
+)
+
+type testReplicatedRelay struct {
+	testRelay
+	factor, quorum int
+}
+
+func (tr *testReplicatedRelay) ReplicationFactor() int { return tr.factor }
+func (tr *testReplicatedRelay) WriteQuorum() int       { return tr.quorum }
+
+type testListerStorage struct {
+	testStorage
+	replicas []string
+	byAuthor map[string][]string
+}
+
+func (st *testListerStorage) GetPeers(pubkey string, n int) []string {
+	replicas := st.replicas
+	if r, ok := st.byAuthor[pubkey]; ok {
+		replicas = r
+	}
+	if n > 0 && len(replicas) > n {
+		return replicas[:n]
+	}
+	return replicas
+}
+
+func (st *testListerStorage) GetAllPeers() []string {
+	return st.replicas
+}
+
+func TestReplicas(t *testing.T) {
+	store := &testListerStorage{replicas: []string{"a", "b", "c"}}
+
+	addrs, quorum := replicas(&testRelay{storage: store}, "pk")
+	if len(addrs) != 0 || quorum != 1 {
+		t.Errorf("without Replicator got %v, %d; want the single GetPeer result", addrs, quorum)
+	}
+
+	addrs, quorum = replicas(&testReplicatedRelay{testRelay{storage: store}, 2, 5}, "pk")
+	if len(addrs) != 2 || quorum != 2 {
+		t.Errorf("got %v, %d; want 2 replicas and quorum capped to 2", addrs, quorum)
+	}
+}
+
+func TestMergeEvents(t *testing.T) {
+	now := time.Now()
+	a := nostr.Event{ID: "a", CreatedAt: now.Add(-time.Minute)}
+	b := nostr.Event{ID: "b", CreatedAt: now}
+	c := nostr.Event{ID: "c", CreatedAt: now.Add(-time.Hour)}
+
+	events := mergeEvents([]nostr.Event{a, c}, []nostr.Event{b, a}, nil)
+	if len(events) != 3 {
+		t.Fatalf("got %d events; want 3", len(events))
+	}
+	for i, id := range []string{"b", "a", "c"} {
+		if events[i].ID != id {
+			t.Errorf("events[%d] = %s; want %s", i, events[i].ID, id)
+		}
+	}
+}
+
+func TestPlanQuery(t *testing.T) {
+	store := &testListerStorage{
+		replicas: []string{"a", "b", "c"},
+		byAuthor: map[string][]string{
+			"alice": {"a"},
+			"bob":   {"b"},
+			"carol": {"a"},
+		},
+	}
+	relay := &testReplicatedRelay{testRelay{storage: store}, 1, 1}
+
+	plan := planQuery(relay, &nostr.Filter{Kinds: []int{1}}, 0)
+	if len(plan) != 3 {
+		t.Errorf("filter without authors went to %d peers; want all 3", len(plan))
+	}
+
+	plan = planQuery(relay, &nostr.Filter{Authors: []string{"alice", "bob", "carol"}, Kinds: []int{1}}, 0)
+	if len(plan) != 2 {
+		t.Fatalf("plan = %v; want 2 peers", plan)
+	}
+	if got := plan["a"].Authors; len(got) != 2 || got[0] != "alice" || got[1] != "carol" {
+		t.Errorf("peer a authors = %v; want [alice carol]", got)
+	}
+	if got := plan["b"].Authors; len(got) != 1 || got[0] != "bob" {
+		t.Errorf("peer b authors = %v; want [bob]", got)
+	}
+	if len(plan["a"].Kinds) != 1 {
+		t.Error("split filter lost its kinds")
+	}
+
+	plan = planQuery(relay, &nostr.Filter{Tags: nostr.TagMap{"p": []string{"bob"}}}, 0)
+	if _, ok := plan["b"]; len(plan) != 1 || !ok {
+		t.Errorf("p-tag filter plan = %v; want only peer b", plan)
+	}
+
+	// counts only ask one replica of each author
+	store.byAuthor["alice"] = []string{"a", "c"}
+	replicated := &testReplicatedRelay{testRelay{storage: store}, 2, 1}
+	if plan = planQuery(replicated, &nostr.Filter{Authors: []string{"alice"}}, 0); len(plan) != 2 {
+		t.Errorf("plan = %v; want both replicas", plan)
+	}
+	if plan = planQuery(replicated, &nostr.Filter{Authors: []string{"alice"}}, 1); len(plan) != 1 || plan["a"] == nil {
+		t.Errorf("plan = %v; want only peer a", plan)
+	}
+}
+
+type testOutboxRelay struct {
+	testReplicatedRelay
+	queued []nostr.Event
+}
+
+func (tr *testOutboxRelay) QueueEvent(evt nostr.Event) error {
+	tr.queued = append(tr.queued, evt)
+	return nil
+}
+
+// offlinePeer returns the address of a peer that is gone, and a client to call it.
+func offlinePeer(t *testing.T) (string, *ql.Client) {
+	t.Helper()
+	newHost := func() host.Host {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	gone := newHost()
+	address := gone.Addrs()[0].String() + "/p2p/" + gone.ID().String()
+	gone.Close()
+	h := newHost()
+	t.Cleanup(func() { h.Close() })
+	return address, ql.NewClient(h)
+}
+
+func TestSendEventQueuesForOfflinePeers(t *testing.T) {
+	address, client := offlinePeer(t)
+	store := &testListerStorage{byAuthor: map[string][]string{"offline": {address}}}
+	relay := &testOutboxRelay{testReplicatedRelay: testReplicatedRelay{testRelay{storage: store}, 1, 1}}
+
+	ok, msg := SendEvent(relay, nostr.Event{ID: "a", PubKey: "offline", Kind: 1}, client, nil, nil)
+	if !ok || !strings.HasPrefix(msg, "queued:") {
+		t.Errorf("SendEvent = %v, %q; want the event queued", ok, msg)
+	}
+	if len(relay.queued) != 1 {
+		t.Errorf("queued %d events; want 1", len(relay.queued))
+	}
+
+	ok, _ = SendEvent(&relay.testReplicatedRelay, nostr.Event{ID: "b", PubKey: "offline", Kind: 1}, client, nil, nil)
+	if ok {
+		t.Error("SendEvent without an outbox accepted an event its peer didn't get")
+	}
+
+	// a pubkey no peer serves has no replica to queue for
+	ok, msg = SendEvent(relay, nostr.Event{ID: "c", PubKey: "unknown", Kind: 1}, client, nil, nil)
+	if ok || len(relay.queued) != 1 {
+		t.Errorf("SendEvent = %v, %q with %d queued; want the event refused", ok, msg, len(relay.queued))
+	}
+}
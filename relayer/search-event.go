@@ -0,0 +1,75 @@
+package relayer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/ql"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SearchPeers runs a NIP-50 search on the peers and merges their results by
+// relevance, keeping the best score of replicated events. Peers that can't
+// search or fail are left out, an error is only returned when none of them
+// answered.
+func SearchPeers(ctx context.Context, filter *nostr.Filter, search string, relay Relay, client *ql.Client, span trace.Span) ([]storage.SearchResult, error) {
+	plan := planQuery(relay, filter, 0)
+	if len(plan) == 0 {
+		return nil, fmt.Errorf("error: failed to search: no peer available")
+	}
+	ctx, cancel := peerContext(ctx)
+	defer cancel()
+
+	type result struct {
+		address string
+		results []storage.SearchResult
+		err     error
+	}
+	answers := make(chan result, len(plan))
+	for address, f := range plan {
+		go func(address string, f *nostr.Filter) {
+			results, err := client.SearchEvents(ctx, address, f, search, span)
+			answers <- result{address, results, err}
+		}(address, f)
+	}
+
+	log := DefaultLogger()
+	best := make(map[string]storage.SearchResult)
+	var err error
+	answered := 0
+	for range plan {
+		ans := <-answers
+		if ans.err != nil {
+			log.WarningfWithContext(ctx, "peer %s failed to search: %v", ans.address, ans.err)
+			err = ans.err
+			continue
+		}
+		answered++
+		for _, r := range ans.results {
+			if prev, ok := best[r.Event.ID]; !ok || r.Score > prev.Score {
+				best[r.Event.ID] = r
+			}
+		}
+	}
+	if answered == 0 {
+		return nil, fmt.Errorf("error: failed to search: %s", err.Error())
+	}
+
+	merged := make([]storage.SearchResult, 0, len(best))
+	for _, r := range best {
+		merged = append(merged, r)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].Score != merged[j].Score {
+			return merged[i].Score > merged[j].Score
+		}
+		return merged[i].Event.CreatedAt.After(merged[j].Event.CreatedAt)
+	})
+	if filter.Limit > 0 && len(merged) > filter.Limit {
+		merged = merged[:filter.Limit]
+	}
+	return merged, nil
+}
@@ -0,0 +1,55 @@
+package relayer
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/storage/memory"
+)
+
+func TestSearch(t *testing.T) {
+	store := &memory.MemoryBackend{}
+	srv := startTestRelay(t, &testRelay{storage: store})
+	defer srv.Shutdown(context.Background())
+
+	for i, content := range []string{"nostr relays", "hello nostr", "unrelated"} {
+		evt := nostr.Event{ID: string(rune('a' + i)), PubKey: "alice", Kind: 1, Content: content, CreatedAt: time.Unix(int64(i), 0)}
+		if err := store.SaveEvent(&evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+srv.Addr(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteJSON([]interface{}{"REQ", "s", map[string]interface{}{"kinds": []int{1}, "search": "relays nostr"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the event matching both words comes first, although it is older
+	var got []string
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var reply []json.RawMessage
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatal(err)
+		}
+		var typ string
+		json.Unmarshal(reply[0], &typ)
+		if typ == "EOSE" {
+			break
+		}
+		var evt nostr.Event
+		json.Unmarshal(reply[2], &evt)
+		got = append(got, evt.ID)
+	}
+	if len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("got events %v, want [a b]", got)
+	}
+}
@@ -2,31 +2,86 @@ package relayer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
-	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/sithumonline/demedia-nostr/relayer/ql"
 	"go.opentelemetry.io/otel/trace"
 )
 
-func SendEvent(relay Relay, evt nostr.Event, host host.Host, ctx context.Context, span trace.Span) (accepted bool, message string) {
-	store := relay.Storage()
-
-	if !relay.AcceptEvent(&evt) {
-		return false, "blocked: event blocked by relay"
+func SendEvent(relay Relay, evt nostr.Event, client *ql.Client, ctx context.Context, span trace.Span) (accepted bool, message string) {
+	if err := CheckEvent(relay, &evt); err != nil {
+		return false, err.Error()
 	}
 
+	// the reply when the peers already had the event, which is still accepted
+	duplicate, stored := "", false
 	if 20000 <= evt.Kind && evt.Kind < 30000 {
 		// do not store ephemeral events
 	} else {
-		address := store.GetPeer(evt.PubKey)
-		_, sandErr := ql.QlCall(host, ctx, evt, address, "BridgeService", "Ql", "saveEvent", span)
-		if sandErr != nil {
+		addresses, quorum := replicas(relay, evt.PubKey)
+		if len(addresses) == 0 {
+			return false, "error: no peer available to store the event"
+		}
+		ctx, cancel := peerContext(ctx)
+		defer cancel()
+
+		// every replica gets the event, but we only wait for the quorum
+		errs := make(chan error, len(addresses))
+		for _, address := range addresses {
+			go func(address string) {
+				errs <- client.SaveEvent(ctx, address, &evt, span)
+			}(address)
+		}
+
+		acks := 0
+		unreachable := true
+		var sandErr error
+		for range addresses {
+			err := <-errs
+			if qe := ql.AsError(err); qe != nil && qe.Code == ql.CodeDuplicate {
+				duplicate = qe.Error()
+				err = nil
+			} else if err == nil {
+				stored = true
+			}
+			if err != nil {
+				sandErr = err
+				unreachable = unreachable && ql.IsUnreachable(err)
+			} else {
+				acks++
+			}
+			if acks >= quorum {
+				break
+			}
+		}
+
+		if acks < quorum {
+			// hold the event until a peer is back when none of them refused it
+			if outbox, ok := relay.(Outbox); ok && unreachable {
+				if err := outbox.QueueEvent(evt); err != nil {
+					return false, fmt.Sprintf("error: failed to queue: %s", err.Error())
+				}
+				notifyListeners(&evt)
+				return true, "queued: peer is offline, the event will be delivered when it is back"
+			}
+			if sandErr == nil {
+				return false, fmt.Sprintf("error: %d peers available for a write quorum of %d", len(addresses), quorum)
+			}
+			var qe *ql.Error
+			if errors.As(sandErr, &qe) {
+				// the peer refused it, its reason already has a NIP-20 prefix
+				return false, qe.Error()
+			}
 			return false, fmt.Sprintf("error: failed to sand: %s", sandErr.Error())
 		}
 	}
 
+	if duplicate != "" && !stored {
+		// its listeners got it the first time
+		return true, duplicate
+	}
 	notifyListeners(&evt)
 
 	return true, ""
@@ -18,6 +18,8 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/sithumonline/demedia-nostr/blob"
 	"github.com/sithumonline/demedia-nostr/ipfs"
+	"github.com/sithumonline/demedia-nostr/relayer/ql"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
 	"github.com/uptrace/opentelemetry-go-extra/otellogrus"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
 	"go.opentelemetry.io/otel/attribute"
@@ -25,9 +27,70 @@ import (
 )
 
 // Settings specify initial startup parameters for Start and StartConf.
+// Zero values keep the defaults of the options, see Option.
 type Settings struct {
 	Host string `envconfig:"HOST" default:"0.0.0.0"`
 	Port string `envconfig:"PORT" default:"7447"`
+
+	PongWait        time.Duration `envconfig:"WS_PONG_WAIT"`
+	MaxMessageSize  int64         `envconfig:"WS_MAX_MESSAGE_SIZE"`
+	ReadBufferSize  int           `envconfig:"WS_READ_BUFFER_SIZE"`
+	WriteBufferSize int           `envconfig:"WS_WRITE_BUFFER_SIZE"`
+	QueueSize       int           `envconfig:"WS_QUEUE_SIZE"`
+	Workers         int           `envconfig:"WS_WORKERS"`
+
+	PeerCallTimeout   time.Duration `envconfig:"PEER_CALL_TIMEOUT"`
+	PeerQueryTimeout  time.Duration `envconfig:"PEER_QUERY_TIMEOUT"`
+	MediaFetchTimeout time.Duration `envconfig:"MEDIA_FETCH_TIMEOUT"`
+	MaxQueriedEvents  int           `envconfig:"MAX_QUERIED_EVENTS"`
+	MaxStreamedEvents int           `envconfig:"MAX_STREAMED_EVENTS"`
+
+	// time StartConfContext gives the server to shut down
+	ShutdownTimeout time.Duration `envconfig:"SHUTDOWN_TIMEOUT" default:"10s"`
+}
+
+// options returns the options set in s.
+func (s Settings) options() []Option {
+	var opts []Option
+	if s.PongWait > 0 {
+		opts = append(opts, WithPongWait(s.PongWait))
+	}
+	if s.MaxMessageSize > 0 {
+		opts = append(opts, WithMaxMessageSize(s.MaxMessageSize))
+	}
+	if s.ReadBufferSize > 0 || s.WriteBufferSize > 0 {
+		read, write := s.ReadBufferSize, s.WriteBufferSize
+		if read <= 0 {
+			read = DefaultReadBufferSize
+		}
+		if write <= 0 {
+			write = DefaultWriteBufferSize
+		}
+		opts = append(opts, WithBufferSizes(read, write))
+	}
+	if s.QueueSize > 0 {
+		opts = append(opts, WithQueueSize(s.QueueSize))
+	}
+	if s.Workers > 0 {
+		opts = append(opts, WithWorkers(s.Workers))
+	}
+	if s.PeerCallTimeout > 0 || s.PeerQueryTimeout > 0 {
+		call, query := s.PeerCallTimeout, s.PeerQueryTimeout
+		if call <= 0 {
+			call = DefaultPeerCallTimeout
+		}
+		if query <= 0 {
+			query = DefaultPeerQueryTimeout
+		}
+		opts = append(opts, WithPeerTimeouts(call, query))
+	}
+	if s.MediaFetchTimeout > 0 {
+		opts = append(opts, WithMediaFetchTimeout(s.MediaFetchTimeout))
+	}
+	if s.MaxQueriedEvents > 0 || s.MaxStreamedEvents > 0 {
+		opts = append(opts, WithQueryLimits(s.MaxQueriedEvents, s.MaxStreamedEvents))
+	}
+	return opts
 }
 
 // Start calls StartConf with Settings parsed from the process environment.
@@ -43,10 +106,41 @@ func Start(relay Relay, host host.Host, blob *blob.BlobStorage, ecdsaPvtKey *ecd
 // and starts serving propagating any error returned from [Server.Start].
 func StartConf(s Settings, relay Relay, host host.Host, blob *blob.BlobStorage, ecdsaPvtKey *ecdsa.PrivateKey, ipfs *ipfs.IPFSClient, tc trace.Tracer) error {
 	addr := net.JoinHostPort(s.Host, s.Port)
-	srv := NewServer(addr, relay, host, blob, ecdsaPvtKey, ipfs, tc)
+	srv := NewServer(addr, relay, host, blob, ecdsaPvtKey, ipfs, tc, s.options()...)
 	return srv.Start()
 }
 
+// StartConfContext is StartConf shutting the server down once ctx is done,
+// giving it s.ShutdownTimeout to close the connections of its clients.
+func StartConfContext(ctx context.Context, s Settings, relay Relay, host host.Host, blob *blob.BlobStorage, ecdsaPvtKey *ecdsa.PrivateKey, ipfs *ipfs.IPFSClient, tc trace.Tracer) error {
+	addr := net.JoinHostPort(s.Host, s.Port)
+	srv := NewServer(addr, relay, host, blob, ecdsaPvtKey, ipfs, tc, s.options()...)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Start()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	// Shutdown needs the http server, which is set once the relay is initialized
+	select {
+	case err := <-errs:
+		return err
+	case <-srv.serving:
+	}
+
+	sctx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(sctx)
+	if serr := <-errs; err == nil {
+		err = serr
+	}
+	return err
+}
+
 // Server is a base for package users to implement nostr relays.
 // It can serve HTTP requests and websockets, passing control over to a relay implementation.
 //
@@ -77,6 +171,9 @@ type Server struct {
 
 	host host.Host
 
+	// calls the peers, set when host is
+	client *ql.Client
+
 	blob *blob.BlobStorage
 
 	ecdsaPvtKey *ecdsa.PrivateKey
@@ -84,29 +181,62 @@ type Server struct {
 	ipfs *ipfs.IPFSClient
 
 	tracer trace.Tracer
+
+	// set when the relay is a RateLimiter
+	limiter *limiter
+
+	// see Option
+	pongWait       time.Duration
+	maxMessageSize int64
+	queueSize      int
+	upgrader       websocket.Upgrader
+
+	// semaphore of the messages being handled
+	workers chan struct{}
+
+	peerTimeouts      peerTimeouts
+	mediaFetchTimeout time.Duration
+	// passed to a QueryLimiter storage when set
+	queryLimits storage.QueryLimits
+
+	// closed once httpServer is set
+	serving chan struct{}
 }
 
-// NewServer creates a relay server with sensible defaults.
+// NewServer creates a relay server with sensible defaults, which opts change.
 // The provided address is used to listen and respond to HTTP requests.
-func NewServer(addr string, relay Relay, host host.Host, blob *blob.BlobStorage, ecdsaPvtKey *ecdsa.PrivateKey, ipfs *ipfs.IPFSClient, tc trace.Tracer) *Server {
+func NewServer(addr string, relay Relay, host host.Host, blob *blob.BlobStorage, ecdsaPvtKey *ecdsa.PrivateKey, ipfs *ipfs.IPFSClient, tc trace.Tracer, opts ...Option) *Server {
 	srv := &Server{
 		Log:         DefaultLogger(),
 		addr:        addr,
 		relay:       relay,
 		router:      mux.NewRouter(),
 		clients:     make(map[*websocket.Conn]struct{}),
+		serving:     make(chan struct{}),
 		host:        host,
 		blob:        blob,
 		ecdsaPvtKey: ecdsaPvtKey,
 		ipfs:        ipfs,
 		tracer:      tc,
 	}
+	defaultOptions(srv)
+	for _, opt := range opts {
+		opt(srv)
+	}
+	if host != nil {
+		srv.client = ql.NewClient(host)
+	}
 	srv.router.Use(otelmux.Middleware(relay.Name()))
 	srv.router.Path("/").Headers("Upgrade", "websocket").HandlerFunc(srv.handleWebsocket)
 	srv.router.Path("/").Headers("Accept", "application/nostr+json").HandlerFunc(srv.handleNIP11)
 	return srv
 }
 
+// QlClient returns the client used to call the peers, nil without a libp2p host.
+func (s *Server) QlClient() *ql.Client {
+	return s.client
+}
+
 // Router returns an http.Handler used to handle server's in-flight HTTP requests.
 // By default, the router is setup to handle websocket upgrade and NIP-11 requests.
 //
@@ -154,9 +284,16 @@ func (s *Server) startListener(ln net.Listener) error {
 	if err := s.relay.Init(); err != nil {
 		return fmt.Errorf("relay init: %w", err)
 	}
+	if limiter, ok := s.relay.Storage().(QueryLimiter); ok && s.queryLimits != (storage.QueryLimits{}) {
+		limiter.SetQueryLimits(s.queryLimits)
+	}
 	if err := s.relay.Storage().Init(); err != nil {
 		return fmt.Errorf("storage init: %w", err)
 	}
+	if rl, ok := s.relay.(RateLimiter); ok {
+		s.limiter = newLimiter(rl.RateLimits())
+	}
+	s.registerGauges()
 
 	// push events from implementations, if any
 	if inj, ok := s.relay.(Injector); ok {
@@ -167,6 +304,11 @@ func (s *Server) startListener(ln net.Listener) error {
 		}()
 	}
 
+	// follow the new events of the peers, if this is a hub
+	if lister, ok := s.relay.Storage().(PeerLister); ok && s.host != nil {
+		go s.followPeers(lister)
+	}
+
 	s.httpServer = &http.Server{
 		Handler:      cors.Default().Handler(s),
 		Addr:         s.addr,
@@ -177,6 +319,7 @@ func (s *Server) startListener(ln net.Listener) error {
 	s.httpServer.RegisterOnShutdown(s.disconnectAllClients)
 	// final callback, just before serving http
 	s.relay.OnInitialized(s)
+	close(s.serving)
 
 	// start accepting incoming requests
 	s.Log.Infof("listening on %s", s.addr)
@@ -211,6 +354,19 @@ func (s *Server) disconnectAllClients() {
 	}
 }
 
+var (
+	logHooksMu sync.Mutex
+	logHooks   []log.Hook
+)
+
+// AddLogHook adds h to the loggers created by DefaultLogger from now on, to
+// ship the logs somewhere else than stdout.
+func AddLogHook(h log.Hook) {
+	logHooksMu.Lock()
+	defer logHooksMu.Unlock()
+	logHooks = append(logHooks, h)
+}
+
 func DefaultLogger() Logger {
 	l := log.New()
 	l.Out = os.Stdout
@@ -222,6 +378,11 @@ func DefaultLogger() Logger {
 		log.WarnLevel,
 		log.InfoLevel,
 	)))
+	logHooksMu.Lock()
+	for _, h := range logHooks {
+		l.AddHook(h)
+	}
+	logHooksMu.Unlock()
 
 	return stdLogger{l}
 }
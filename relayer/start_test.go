@@ -33,7 +33,7 @@ func TestServerStartShutdown(t *testing.T) {
 			init: func() error { storeInited = true; return nil },
 		},
 	}
-	srv := NewServer("127.0.0.1:0", rl)
+	srv := NewServer("127.0.0.1:0", rl, nil, nil, nil, nil, testTracer)
 	done := make(chan error)
 	go func() { done <- srv.Start(); close(done) }()
 
@@ -104,3 +104,38 @@ func TestServerShutdownWebsocket(t *testing.T) {
 		t.Error("client took too long to disconnect")
 	}
 }
+
+func TestStartConfContext(t *testing.T) {
+	ready := make(chan struct{})
+	shutdown := false
+	rl := &testRelay{
+		onInitialized: func(*Server) { close(ready) },
+		onShutdown:    func(context.Context) { shutdown = true },
+		storage:       &testStorage{},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		s := Settings{Host: "127.0.0.1", Port: "0", ShutdownTimeout: time.Second}
+		done <- StartConfContext(ctx, s, rl, nil, nil, nil, nil, testTracer)
+	}()
+
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		t.Fatal("StartConfContext took too long to initialize")
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("StartConfContext: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("StartConfContext didn't return once ctx was done")
+	}
+	if !shutdown {
+		t.Error("didn't call testRelay.onShutdown")
+	}
+}
@@ -0,0 +1,33 @@
+package relayer
+
+import (
+	"context"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// StorageWithContext returns store as a [StorageCtx]. The storages that don't
+// implement it get their methods called without the ctx. The duration of the
+// calls is recorded as the relayer.storage.duration metric.
+func StorageWithContext(store Storage) StorageCtx {
+	if sc, ok := store.(StorageCtx); ok {
+		return timedStorage{sc}
+	}
+	return timedStorage{noCtxStorage{store}}
+}
+
+type noCtxStorage struct {
+	store Storage
+}
+
+func (s noCtxStorage) QueryEventsCtx(ctx context.Context, filter *nostr.Filter) ([]nostr.Event, error) {
+	return s.store.QueryEvents(filter)
+}
+
+func (s noCtxStorage) DeleteEventCtx(ctx context.Context, id string, pubkey string) error {
+	return s.store.DeleteEvent(id, pubkey)
+}
+
+func (s noCtxStorage) SaveEventCtx(ctx context.Context, event *nostr.Event) error {
+	return s.store.SaveEvent(event)
+}
@@ -7,19 +7,25 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"math/rand"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sithumonline/demedia-nostr/relayer"
+	"github.com/sithumonline/demedia-nostr/relayer/registry"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
 
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/esutil"
 	"github.com/nbd-wtf/go-nostr"
 )
 
-var _ relayer.Storage = (*ElasticsearchStorage)(nil)
+var (
+	_ relayer.Storage    = (*ElasticsearchStorage)(nil)
+	_ relayer.StorageCtx = (*ElasticsearchStorage)(nil)
+	_ relayer.Searcher   = (*ElasticsearchStorage)(nil)
+)
 
 type IndexedEvent struct {
 	Event         nostr.Event `json:"event"`
@@ -51,17 +57,15 @@ var indexMapping = `
 }
 `
 
-type PeerInfo struct {
-	Address    string
-	LastUpdate time.Time
-}
-
 type ElasticsearchStorage struct {
 	IndexName string
 
-	es  *elasticsearch.Client
-	bi  esutil.BulkIndexer
-	Map map[string]PeerInfo
+	es    *elasticsearch.Client
+	bi    esutil.BulkIndexer
+	peers *registry.Registry
+
+	// serializes the writers of replaceable events
+	replaceMu sync.Mutex
 }
 
 func (ess *ElasticsearchStorage) Init() error {
@@ -105,21 +109,28 @@ func (ess *ElasticsearchStorage) Init() error {
 
 	ess.es = es
 	ess.bi = bi
+	ess.peers = registry.New(nil, 0)
 
 	return nil
 }
 
 func (ess *ElasticsearchStorage) DeleteEvent(id string, pubkey string) error {
-	ctx := context.Background()
+	return ess.DeleteEventCtx(context.Background(), id, pubkey)
+}
+
+func (ess *ElasticsearchStorage) DeleteEventCtx(ctx context.Context, id string, pubkey string) error {
 	// first do get by ID and check that pubkeys match
 	// this is cheaper than doing delete by query, which also doesn't work with bulk indexer.
-	found, _ := ess.getByID(&nostr.Filter{IDs: []string{id}})
+	found, err := ess.getByID(ctx, &nostr.Filter{IDs: []string{id}})
+	if err != nil {
+		return err
+	}
 	if len(found) == 0 || found[0].PubKey != pubkey {
 		return nil
 	}
 
 	done := make(chan error)
-	err := ess.bi.Add(
+	err = ess.bi.Add(
 		ctx,
 		esutil.BulkIndexerItem{
 			Action:     "delete",
@@ -152,7 +163,27 @@ func (ess *ElasticsearchStorage) DeleteEvent(id string, pubkey string) error {
 }
 
 func (ess *ElasticsearchStorage) SaveEvent(evt *nostr.Event) error {
-	ctx := context.Background()
+	return ess.SaveEventCtx(context.Background(), evt)
+}
+
+func (ess *ElasticsearchStorage) SaveEventCtx(ctx context.Context, evt *nostr.Event) error {
+	replaceable := evt.Kind == nostr.KindSetMetadata || evt.Kind == nostr.KindContactList || (10000 <= evt.Kind && evt.Kind < 20000)
+	parameterized := 30000 <= evt.Kind && evt.Kind < 40000
+	if replaceable || parameterized {
+		// the bulk indexer writes in the background, so the versions are
+		// written one at a time and made visible before the next one looks
+		ess.replaceMu.Lock()
+		defer ess.replaceMu.Unlock()
+	}
+
+	found, err := ess.getByID(ctx, &nostr.Filter{IDs: []string{evt.ID}})
+	if err != nil {
+		return err
+	}
+	if len(found) > 0 {
+		return storage.ErrDupEvent
+	}
+
 	ie := &IndexedEvent{
 		Event: *evt,
 	}
@@ -173,58 +204,42 @@ func (ess *ElasticsearchStorage) SaveEvent(evt *nostr.Event) error {
 		return err
 	}
 
-	done := make(chan error)
-
-	// delete replaceable events
-	deleteIDs := []string{}
-	queryForDelete := func(filter *nostr.Filter) {
-		toDelete, _ := ess.QueryEvents(filter)
-		for _, e := range toDelete {
-			// KindRecommendServer: we can't query ES for exact content match
-			// so query by kind and loop over results to compare content
-			if evt.Kind == nostr.KindRecommendServer {
-				if e.Content == evt.Content {
-					deleteIDs = append(deleteIDs, e.ID)
-				}
-			} else {
-				deleteIDs = append(deleteIDs, e.ID)
-			}
-		}
+	if replaceable || parameterized {
+		return ess.saveReplaceable(ctx, evt, data, parameterized)
 	}
-	if evt.Kind == nostr.KindSetMetadata || evt.Kind == nostr.KindContactList || evt.Kind == nostr.KindRecommendServer || (10000 <= evt.Kind && evt.Kind < 20000) {
-		// delete past events from this user
-		queryForDelete(&nostr.Filter{
+
+	if evt.Kind == nostr.KindRecommendServer {
+		// delete past recommend_server events equal to this one: we can't
+		// query ES for exact content match so query by kind and compare
+		past, err := ess.QueryEventsCtx(ctx, &nostr.Filter{
 			Authors: []string{evt.PubKey},
 			Kinds:   []int{evt.Kind},
 		})
-	} else if evt.Kind >= 30000 && evt.Kind < 40000 {
-		// NIP-33
-		d := evt.Tags.GetFirst([]string{"d"})
-		if d != nil {
-			queryForDelete(&nostr.Filter{
-				Authors: []string{evt.PubKey},
-				Kinds:   []int{evt.Kind},
-				Tags: nostr.TagMap{
-					"d": []string{d.Value()},
-				},
-			})
+		if err != nil {
+			return err
+		}
+		for _, e := range past {
+			if e.Content == evt.Content {
+				ess.bi.Add(
+					ctx,
+					esutil.BulkIndexerItem{
+						Action:     "delete",
+						DocumentID: e.ID,
+					})
+			}
 		}
 	}
-	for _, id := range deleteIDs {
-		ess.bi.Add(
-			ctx,
-			esutil.BulkIndexerItem{
-				Action:     "delete",
-				DocumentID: id,
-			})
-	}
+
+	done := make(chan error)
 
 	// adapted from:
 	// https://github.com/elastic/go-elasticsearch/blob/main/_examples/bulk/indexer.go#L196
 	err = ess.bi.Add(
 		ctx,
 		esutil.BulkIndexerItem{
-			Action:     "index",
+			// create fails on an existing ID, which the check above may have
+			// missed while the event was still in the indexer
+			Action:     "create",
 			DocumentID: evt.ID,
 			Body:       bytes.NewReader(data),
 			OnSuccess: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
@@ -233,6 +248,8 @@ func (ess *ElasticsearchStorage) SaveEvent(evt *nostr.Event) error {
 			OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
 				if err != nil {
 					done <- err
+				} else if res.Status == 409 {
+					done <- storage.ErrDupEvent
 				} else {
 					err := fmt.Errorf("ERROR: %s: %s", res.Error.Type, res.Error.Reason)
 					done <- err
@@ -244,34 +261,100 @@ func (ess *ElasticsearchStorage) SaveEvent(evt *nostr.Event) error {
 		return err
 	}
 
-	err = <-done
-	return err
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func (ess *ElasticsearchStorage) GetPeer(pubkey string) string {
-	address := ess.Map[pubkey].Address
-	log.Printf("address: %s, pubkey: %s", address, pubkey)
-	if address == "" {
-		if len(ess.Map) == 0 {
-			return ""
+// saveReplaceable replaces the stored versions of a replaceable event (NIP-16),
+// unless one is newer than evt. Versions have the same pubkey and kind, and when
+// parameterized (NIP-33) the same "d" tag, a missing "d" tag counting as "".
+// It writes around the bulk indexer and waits for the writes to be searchable,
+// the caller holds replaceMu.
+func (ess *ElasticsearchStorage) saveReplaceable(ctx context.Context, evt *nostr.Event, data []byte, parameterized bool) error {
+	filter := &nostr.Filter{
+		Authors: []string{evt.PubKey},
+		Kinds:   []int{evt.Kind},
+	}
+	d := dTag(evt)
+	if parameterized && d != "" {
+		filter.Tags = nostr.TagMap{"d": []string{d}}
+	}
+	versions, err := ess.QueryEventsCtx(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	var older []string
+	for _, v := range versions {
+		if parameterized && dTag(&v) != d {
+			continue
 		}
-		rand.Seed(time.Now().UnixNano())
-		n := rand.Intn(len(ess.Map))
-		i := 0
-		for _, v := range ess.Map {
-			if i == n {
-				return v.Address
-			}
-			i++
+		switch {
+		case v.ID == evt.ID:
+			return storage.ErrDupEvent
+		case v.CreatedAt.After(evt.CreatedAt) || (v.CreatedAt.Equal(evt.CreatedAt) && v.ID < evt.ID):
+			// on equal timestamps the lowest id wins
+			return storage.ErrOlderEvent
 		}
+		older = append(older, v.ID)
+	}
+
+	res, err := ess.es.Create(ess.IndexName, evt.ID, bytes.NewReader(data),
+		ess.es.Create.WithContext(ctx),
+		ess.es.Create.WithRefresh("wait_for"))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 409 {
+		return storage.ErrDupEvent
+	}
+	if res.IsError() {
+		txt, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("%s", txt)
 	}
 
+	for _, id := range older {
+		res, err := ess.es.Delete(ess.IndexName, id,
+			ess.es.Delete.WithContext(ctx),
+			ess.es.Delete.WithRefresh("wait_for"))
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.IsError() && res.StatusCode != 404 {
+			return fmt.Errorf("failed to delete the older version %s: %s", id, res.Status())
+		}
+	}
+	return nil
+}
+
+// dTag returns the value of the first "d" tag of evt, "" when it has none.
+func dTag(evt *nostr.Event) string {
+	if tag := evt.Tags.GetFirst([]string{"d", ""}); tag != nil {
+		return tag.Value()
+	}
+	return ""
+}
+
+func (ess *ElasticsearchStorage) GetPeer(pubkey string) string {
+	address := ess.peers.Get(pubkey)
+	log.Printf("address: %s, pubkey: %s", address, pubkey)
 	return address
 }
 
+func (ess *ElasticsearchStorage) GetPeers(pubkey string, n int) []string {
+	return ess.peers.Lookup(pubkey, n)
+}
+
+func (ess *ElasticsearchStorage) GetAllPeers() []string {
+	return ess.peers.Addresses()
+}
+
 func (ess *ElasticsearchStorage) SavePeer(address string, pubkey string) {
-	ess.Map[pubkey] = PeerInfo{
-		Address:    address,
-		LastUpdate: time.Now(),
-	}
+	ess.peers.Save(address, pubkey)
 }
@@ -13,6 +13,7 @@ import (
 	"github.com/aquasecurity/esquery"
 	"github.com/elastic/go-elasticsearch/v8/esutil"
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
 )
 
 type EsSearchResult struct {
@@ -24,6 +25,7 @@ type EsSearchResult struct {
 			Relation string
 		}
 		Hits []struct {
+			Score  float64      `json:"_score"`
 			Source IndexedEvent `json:"_source"`
 		}
 	}
@@ -33,7 +35,7 @@ type EsCountResult struct {
 	Count int64
 }
 
-func buildDsl(filter *nostr.Filter) ([]byte, error) {
+func buildDsl(filter *nostr.Filter, search string) ([]byte, error) {
 	dsl := esquery.Bool()
 
 	prefixFilter := func(fieldName string, values []string) {
@@ -83,20 +85,26 @@ func buildDsl(filter *nostr.Filter) ([]byte, error) {
 	}
 
 	// search
-	// if filter.Search != "" {
-	// 	dsl.Must(esquery.Match("content_search", filter.Search))
-	// }
+	if search != "" {
+		dsl.Must(esquery.Match("content_search", search))
+	}
 
 	return json.Marshal(esquery.Query(dsl))
 }
 
-func (ess *ElasticsearchStorage) getByID(filter *nostr.Filter) ([]*nostr.Event, error) {
+func (ess *ElasticsearchStorage) getByID(ctx context.Context, filter *nostr.Filter) ([]*nostr.Event, error) {
 	got, err := ess.es.Mget(
 		esutil.NewJSONReader(filter),
+		ess.es.Mget.WithContext(ctx),
 		ess.es.Mget.WithIndex(ess.IndexName))
 	if err != nil {
 		return nil, err
 	}
+	defer got.Body.Close()
+	if got.IsError() {
+		txt, _ := io.ReadAll(got.Body)
+		return nil, fmt.Errorf("%s", txt)
+	}
 
 	var mgetResponse struct {
 		Docs []struct {
@@ -118,27 +126,32 @@ func (ess *ElasticsearchStorage) getByID(filter *nostr.Filter) ([]*nostr.Event,
 	return events, nil
 }
 
-func (ess *ElasticsearchStorage) QueryEvents(filter *nostr.Filter) (events []nostr.Event, err error) {
-	ctx := context.Background()
-	ch := make(chan *nostr.Event)
+func (ess *ElasticsearchStorage) QueryEvents(filter *nostr.Filter) ([]nostr.Event, error) {
+	return ess.QueryEventsCtx(context.Background(), filter)
+}
 
+func (ess *ElasticsearchStorage) QueryEventsCtx(ctx context.Context, filter *nostr.Filter) (events []nostr.Event, err error) {
 	if filter == nil {
 		return nil, errors.New("filter cannot be null")
 	}
+	if filter.Kinds != nil && len(filter.Kinds) == 0 {
+		// kinds being [] mean you won't get anything
+		return nil, nil
+	}
 
 	// optimization: get by id
 	if isGetByID(filter) {
-		if evts, err := ess.getByID(filter); err == nil {
-			for _, evt := range evts {
-				ch <- evt
-			}
-			close(ch)
-		} else {
+		evts, err := ess.getByID(ctx, filter)
+		if err != nil {
 			return nil, fmt.Errorf("error getting by id: %w", err)
 		}
+		for _, evt := range evts {
+			events = append(events, *evt)
+		}
+		return events, nil
 	}
 
-	dsl, err := buildDsl(filter)
+	dsl, err := buildDsl(filter, "")
 	if err != nil {
 		return nil, err
 	}
@@ -234,18 +247,21 @@ func (ess *ElasticsearchStorage) CountEvents(ctx context.Context, filter *nostr.
 		return 0, errors.New("filter cannot be null")
 	}
 
-	count := int64(0)
+	if filter.Kinds != nil && len(filter.Kinds) == 0 {
+		// kinds being [] mean you won't get anything
+		return 0, nil
+	}
 
 	// optimization: get by id
 	if isGetByID(filter) {
-		if evts, err := ess.getByID(filter); err == nil {
-			count += int64(len(evts))
-		} else {
+		evts, err := ess.getByID(ctx, filter)
+		if err != nil {
 			return 0, fmt.Errorf("error getting by id: %w", err)
 		}
+		return int64(len(evts)), nil
 	}
 
-	dsl, err := buildDsl(filter)
+	dsl, err := buildDsl(filter, "")
 	if err != nil {
 		return 0, err
 	}
@@ -258,7 +274,7 @@ func (ess *ElasticsearchStorage) CountEvents(ctx context.Context, filter *nostr.
 		es.Count.WithBody(bytes.NewReader(dsl)),
 	)
 	if err != nil {
-		log.Fatalf("Error getting response: %s", err)
+		return 0, err
 	}
 	defer res.Body.Close()
 
@@ -273,5 +289,57 @@ func (ess *ElasticsearchStorage) CountEvents(ctx context.Context, filter *nostr.
 		return 0, err
 	}
 
-	return r.Count + count, nil
+	return r.Count, nil
+}
+
+// SearchEvents runs a NIP-50 search, ranking the events matching filter by the
+// relevance of their content to search.
+func (ess *ElasticsearchStorage) SearchEvents(ctx context.Context, filter *nostr.Filter, search string) ([]storage.SearchResult, error) {
+	if filter == nil {
+		return nil, errors.New("filter cannot be null")
+	}
+	if filter.Kinds != nil && len(filter.Kinds) == 0 {
+		// kinds being [] mean you won't get anything
+		return nil, nil
+	}
+
+	dsl, err := buildDsl(filter, search)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := 1000
+	if filter.Limit > 0 && filter.Limit < limit {
+		limit = filter.Limit
+	}
+
+	es := ess.es
+	res, err := es.Search(
+		es.Search.WithContext(ctx),
+		es.Search.WithIndex(ess.IndexName),
+
+		es.Search.WithBody(bytes.NewReader(dsl)),
+		es.Search.WithSize(limit),
+		es.Search.WithSort("_score:desc", "event.created_at:desc"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		txt, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("%s", txt)
+	}
+
+	var r EsSearchResult
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+
+	results := make([]storage.SearchResult, 0, len(r.Hits.Hits))
+	for _, e := range r.Hits.Hits {
+		results = append(results, storage.SearchResult{Event: e.Source.Event, Score: e.Score})
+	}
+	return results, nil
 }
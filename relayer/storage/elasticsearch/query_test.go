@@ -5,13 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/nbd-wtf/go-nostr"
 )
 
 func TestQuery(t *testing.T) {
-	now := nostr.Now()
-	yesterday := now - 60*60*24
+	now := time.Now()
+	yesterday := now.Add(-24 * time.Hour)
 	filter := &nostr.Filter{
 		IDs:   []string{"abc", "123", "971b9489b4fd4e41a85951607922b982d981fa9d55318bc304f21f390721404c"},
 		Kinds: []int{0, 1},
@@ -19,16 +20,18 @@ func TestQuery(t *testing.T) {
 			"e": []string{"abc"},
 			"p": []string{"aaa", "bbb"},
 		},
-		Since:  &yesterday,
-		Until:  &now,
-		Limit:  100,
-		Search: "other stuff",
+		Since: &yesterday,
+		Until: &now,
+		Limit: 100,
 	}
 
-	dsl, err := buildDsl(filter)
+	dsl, err := buildDsl(filter, "other stuff")
 	if err != nil {
 		t.Fatal(err)
 	}
+	if !bytes.Contains(dsl, []byte(`"content_search":{"query":"other stuff"}`)) {
+		t.Errorf("search missing from %s", dsl)
+	}
 	pprint(dsl)
 }
 
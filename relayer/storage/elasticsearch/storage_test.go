@@ -0,0 +1,33 @@
+package elasticsearch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sithumonline/demedia-nostr/relayer"
+	"github.com/sithumonline/demedia-nostr/relayer/storage/storagetest"
+)
+
+// TestStorage runs against the cluster in TEST_ES_URL, in an index of its own.
+func TestStorage(t *testing.T) {
+	url := os.Getenv("TEST_ES_URL")
+	if url == "" {
+		t.Skip("TEST_ES_URL is not set")
+	}
+	t.Setenv("ELASTICSEARCH_URL", url)
+
+	storagetest.Run(t, func(t *testing.T) relayer.Storage {
+		ess := &ElasticsearchStorage{IndexName: fmt.Sprintf("test-%d", time.Now().UnixNano())}
+		if err := ess.Init(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			ess.bi.Close(context.Background())
+			ess.es.Indices.Delete([]string{ess.IndexName})
+		})
+		return ess
+	})
+}
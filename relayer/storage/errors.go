@@ -3,3 +3,6 @@ package storage
 import "errors"
 
 var ErrDupEvent = errors.New("duplicate: event already exists")
+
+// ErrOlderEvent is returned when saving a replaceable event older than the stored one.
+var ErrOlderEvent = errors.New("duplicate: a newer version of this event already exists")
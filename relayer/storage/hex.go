@@ -0,0 +1,15 @@
+package storage
+
+// IsHexPrefix reports whether s is a lowercase hex prefix of an event id or
+// pubkey, as the filters of NIP-01 accept. Those are safe to put in a LIKE pattern.
+func IsHexPrefix(s string) bool {
+	if len(s) == 0 || len(s) > 64 {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,33 @@
+package storage
+
+// Defaults of the QueryLimits.
+const (
+	// Most events QueryEvents returns, the whole result is held in memory.
+	DefaultMaxQueriedEvents = 100
+
+	// Most events StreamEvents hands out.
+	DefaultMaxStreamedEvents = 10000
+)
+
+// QueryLimits bound the events a storage returns for a filter, whatever its
+// limit. Zero values keep the defaults.
+type QueryLimits struct {
+	MaxQueriedEvents  int
+	MaxStreamedEvents int
+}
+
+// Queried returns the most events QueryEvents returns.
+func (l QueryLimits) Queried() int {
+	if l.MaxQueriedEvents > 0 {
+		return l.MaxQueriedEvents
+	}
+	return DefaultMaxQueriedEvents
+}
+
+// Streamed returns the most events StreamEvents hands out.
+func (l QueryLimits) Streamed() int {
+	if l.MaxStreamedEvents > 0 {
+		return l.MaxStreamedEvents
+	}
+	return DefaultMaxStreamedEvents
+}
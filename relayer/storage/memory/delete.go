@@ -0,0 +1,12 @@
+package memory
+
+// DeleteEvent deletes the event with id if it was published by pubkey, see NIP-09.
+func (b *MemoryBackend) DeleteEvent(id string, pubkey string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if evt, ok := b.events[id]; ok && evt.PubKey == pubkey {
+		delete(b.events, id)
+	}
+	return nil
+}
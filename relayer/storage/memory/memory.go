@@ -0,0 +1,66 @@
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/registry"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
+)
+
+// MemoryBackend keeps the events and the peers in memory, for tests and for
+// relays that don't need them to survive a restart. The zero value is ready
+// to use once Init is called.
+type MemoryBackend struct {
+	// PeerTTL is how long a registered peer is kept without a ping,
+	// defaults to registry.DefaultTTL.
+	PeerTTL time.Duration
+
+	// Limits bound the events returned for a filter.
+	Limits storage.QueryLimits
+
+	mu     sync.RWMutex
+	events map[string]*nostr.Event // id -> event
+
+	peers *registry.Registry
+}
+
+func (b *MemoryBackend) Init() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.events == nil {
+		b.events = make(map[string]*nostr.Event)
+	}
+	if b.peers == nil {
+		b.peers = registry.New(nil, b.PeerTTL)
+	}
+	return nil
+}
+
+// Peers returns the peer registry, available after Init.
+func (b *MemoryBackend) Peers() *registry.Registry {
+	return b.peers
+}
+
+func (b *MemoryBackend) SavePeer(address string, pubkey string) {
+	b.peers.Save(address, pubkey)
+}
+
+func (b *MemoryBackend) GetPeer(pubkey string) string {
+	return b.peers.Get(pubkey)
+}
+
+func (b *MemoryBackend) GetPeers(pubkey string, n int) []string {
+	return b.peers.Lookup(pubkey, n)
+}
+
+func (b *MemoryBackend) GetAllPeers() []string {
+	return b.peers.Addresses()
+}
+
+// SetQueryLimits sets Limits, see relayer.QueryLimiter.
+func (b *MemoryBackend) SetQueryLimits(limits storage.QueryLimits) {
+	b.Limits = limits
+}
@@ -0,0 +1,157 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
+)
+
+func testBackend(t *testing.T) *MemoryBackend {
+	b := &MemoryBackend{}
+	if err := b.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func ids(events []nostr.Event) []string {
+	ids := make([]string, len(events))
+	for i, evt := range events {
+		ids[i] = evt.ID
+	}
+	return ids
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestQueryEvents(t *testing.T) {
+	b := testBackend(t)
+	for i, evt := range []nostr.Event{
+		{ID: "aa01", PubKey: "alice", Kind: 1, Tags: nostr.Tags{{"e", "x"}}},
+		{ID: "aa02", PubKey: "alice", Kind: 7, Tags: nostr.Tags{{"e", "x"}, {"p", "bob"}}},
+		{ID: "bb03", PubKey: "bob", Kind: 1},
+		{ID: "bb04", PubKey: "bob", Kind: 1, Tags: nostr.Tags{{"emoji", "x"}}},
+	} {
+		evt.CreatedAt = time.Unix(int64(100*(i+1)), 0)
+		if err := b.SaveEvent(&evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	since, until := time.Unix(150, 0), time.Unix(350, 0)
+
+	for _, tc := range []struct {
+		name   string
+		filter nostr.Filter
+		want   []string
+	}{
+		{"all, newest first", nostr.Filter{}, []string{"bb04", "bb03", "aa02", "aa01"}},
+		{"id prefix", nostr.Filter{IDs: []string{"aa"}}, []string{"aa02", "aa01"}},
+		{"author prefix", nostr.Filter{Authors: []string{"bo"}}, []string{"bb04", "bb03"}},
+		{"kinds", nostr.Filter{Kinds: []int{7}}, []string{"aa02"}},
+		{"no kinds", nostr.Filter{Kinds: []int{}}, []string{}},
+		{"tags", nostr.Filter{Tags: nostr.TagMap{"e": {"x"}}}, []string{"aa02", "aa01"}},
+		{"tags and-ed", nostr.Filter{Tags: nostr.TagMap{"e": {"x"}, "p": {"bob"}}}, []string{"aa02"}},
+		{"since and until", nostr.Filter{Since: &since, Until: &until}, []string{"bb03", "aa02"}},
+		{"limit", nostr.Filter{Limit: 1}, []string{"bb04"}},
+	} {
+		events, err := b.QueryEvents(&tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(events); !equal(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSaveReplaceable(t *testing.T) {
+	b := testBackend(t)
+
+	save := func(id string, kind int, d string, createdAt int64) error {
+		evt := &nostr.Event{ID: id, PubKey: "alice", Kind: kind, CreatedAt: time.Unix(createdAt, 0)}
+		if d != "-" {
+			evt.Tags = nostr.Tags{{"d", d}}
+		}
+		return b.SaveEvent(evt)
+	}
+	stored := func(kind int) []string {
+		events, _ := b.QueryEvents(&nostr.Filter{Kinds: []int{kind}})
+		return ids(events)
+	}
+
+	if err := save("m1", 0, "-", 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := save("m2", 0, "-", 200); err != nil {
+		t.Fatal(err)
+	}
+	if err := save("m0", 0, "-", 50); err != storage.ErrOlderEvent {
+		t.Errorf("saving older metadata returned %v", err)
+	}
+	if got := stored(0); !equal(got, []string{"m2"}) {
+		t.Errorf("metadata: got %v", got)
+	}
+
+	if err := save("a1", 30023, "article", 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := save("a2", 30023, "article", 200); err != nil {
+		t.Fatal(err)
+	}
+	if err := save("a2", 30023, "article", 200); err != storage.ErrDupEvent {
+		t.Errorf("saving the same version returned %v", err)
+	}
+	if err := save("b1", 30023, "list", 100); err != nil {
+		t.Fatal(err)
+	}
+	// a missing "d" tag is the same as an empty one
+	if err := save("c1", 30023, "-", 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := save("c2", 30023, "", 200); err != nil {
+		t.Fatal(err)
+	}
+	if got := stored(30023); !equal(got, []string{"a2", "c2", "b1"}) && !equal(got, []string{"c2", "a2", "b1"}) {
+		t.Errorf("parameterized: got %v", got)
+	}
+}
+
+func TestDeleteEvent(t *testing.T) {
+	b := testBackend(t)
+	if err := b.SaveEvent(&nostr.Event{ID: "e1", PubKey: "alice", Kind: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the author can delete an event
+	b.DeleteEvent("e1", "bob")
+	if events, _ := b.QueryEvents(&nostr.Filter{}); len(events) != 1 {
+		t.Errorf("deleted by someone else")
+	}
+	b.DeleteEvent("e1", "alice")
+	if events, _ := b.QueryEvents(&nostr.Filter{}); len(events) != 0 {
+		t.Errorf("not deleted by its author")
+	}
+}
+
+func TestPeers(t *testing.T) {
+	b := testBackend(t)
+	b.SavePeer("/ip4/127.0.0.1/tcp/1", "alice")
+	if got := b.GetPeer("alice"); got != "/ip4/127.0.0.1/tcp/1" {
+		t.Errorf("GetPeer: got %q", got)
+	}
+	if got := b.GetPeers("bob", 1); len(got) != 0 {
+		t.Errorf("GetPeers of an unknown pubkey: got %v", got)
+	}
+}
@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func (b *MemoryBackend) QueryEvents(filter *nostr.Filter) ([]nostr.Event, error) {
+	if filter == nil {
+		return nil, errors.New("filter cannot be null")
+	}
+	return b.match(filter, b.Limits.Queried()), nil
+}
+
+func (b *MemoryBackend) StreamEvents(ctx context.Context, filter *nostr.Filter, send func(nostr.Event) error) error {
+	if filter == nil {
+		return errors.New("filter cannot be null")
+	}
+	for _, evt := range b.match(filter, b.Limits.Streamed()) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := send(evt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CountEvents counts the events matching filter, regardless of its limit (NIP-45).
+func (b *MemoryBackend) CountEvents(ctx context.Context, filter *nostr.Filter) (int64, error) {
+	if filter == nil {
+		return 0, errors.New("filter cannot be null")
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var count int64
+	for _, evt := range b.events {
+		if filter.Matches(evt) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// match returns copies of the events matching filter, newest first and at
+// most filter.Limit or maxLimit of them.
+func (b *MemoryBackend) match(filter *nostr.Filter, maxLimit int) []nostr.Event {
+	limit := filter.Limit
+	if limit < 1 || limit > maxLimit {
+		limit = maxLimit
+	}
+
+	b.mu.RLock()
+	var events []nostr.Event
+	for _, evt := range b.events {
+		if filter.Matches(evt) {
+			events = append(events, *evt)
+		}
+	}
+	b.mu.RUnlock()
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].CreatedAt.After(events[j].CreatedAt)
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events
+}
@@ -0,0 +1,69 @@
+package memory
+
+import (
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
+)
+
+func (b *MemoryBackend) SaveEvent(evt *nostr.Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.events[evt.ID]; ok {
+		return storage.ErrDupEvent
+	}
+
+	// react to different kinds of events
+	switch {
+	case evt.Kind == nostr.KindSetMetadata || evt.Kind == nostr.KindContactList || (10000 <= evt.Kind && evt.Kind < 20000):
+		// replace the past events from this user
+		if err := b.replace(evt, func(old *nostr.Event) bool { return true }); err != nil {
+			return err
+		}
+	case evt.Kind == nostr.KindRecommendServer:
+		// delete past recommend_server events equal to this one
+		for id, old := range b.events {
+			if old.PubKey == evt.PubKey && old.Kind == evt.Kind && old.Content == evt.Content {
+				delete(b.events, id)
+			}
+		}
+	case 30000 <= evt.Kind && evt.Kind < 40000:
+		// events with the same pubkey, kind and "d" tag are versions of each
+		// other, a missing "d" tag counts as "", see NIP-33
+		d := dTag(evt)
+		if err := b.replace(evt, func(old *nostr.Event) bool { return dTag(old) == d }); err != nil {
+			return err
+		}
+	}
+
+	stored := *evt
+	b.events[evt.ID] = &stored
+	return nil
+}
+
+// replace deletes the stored versions of evt, the events with its pubkey and
+// kind for which same returns true, unless one of them is newer than evt.
+func (b *MemoryBackend) replace(evt *nostr.Event, same func(old *nostr.Event) bool) error {
+	var versions []string
+	for id, old := range b.events {
+		if old.PubKey != evt.PubKey || old.Kind != evt.Kind || !same(old) {
+			continue
+		}
+		if old.CreatedAt.After(evt.CreatedAt) || (old.CreatedAt.Equal(evt.CreatedAt) && old.ID < evt.ID) {
+			// on equal timestamps the lowest id wins
+			return storage.ErrOlderEvent
+		}
+		versions = append(versions, id)
+	}
+	for _, id := range versions {
+		delete(b.events, id)
+	}
+	return nil
+}
+
+func dTag(evt *nostr.Event) string {
+	if tag := evt.Tags.GetFirst([]string{"d", ""}); tag != nil {
+		return tag.Value()
+	}
+	return ""
+}
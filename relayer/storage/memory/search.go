@@ -0,0 +1,61 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
+)
+
+// SearchEvents runs a NIP-50 search. Events match when their content has any
+// of the words of search, and score the share of these words they have.
+// Direct messages are never searched.
+func (b *MemoryBackend) SearchEvents(ctx context.Context, filter *nostr.Filter, search string) ([]storage.SearchResult, error) {
+	if filter == nil {
+		return nil, errors.New("filter cannot be null")
+	}
+	terms := strings.Fields(strings.ToLower(search))
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	b.mu.RLock()
+	var results []storage.SearchResult
+	for _, evt := range b.events {
+		if evt.Kind == 4 || !filter.Matches(evt) {
+			continue
+		}
+		words := strings.Fields(strings.ToLower(evt.Content))
+		matched := 0
+		for _, term := range terms {
+			for _, word := range words {
+				if word == term {
+					matched++
+					break
+				}
+			}
+		}
+		if matched > 0 {
+			results = append(results, storage.SearchResult{Event: *evt, Score: float64(matched) / float64(len(terms))})
+		}
+	}
+	b.mu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Event.CreatedAt.After(results[j].Event.CreatedAt)
+	})
+	limit := filter.Limit
+	if limit < 1 || limit > b.Limits.Queried() {
+		limit = b.Limits.Queried()
+	}
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
@@ -0,0 +1,60 @@
+package postgresql
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const migrateUsage = "usage: migrate [status | up | to <version>]"
+
+// MigrateCommand runs the migrate subcommand of the hub and the peer against
+// b, which doesn't need to be initialized:
+//
+//	migrate status        lists the migrations and whether they are applied
+//	migrate up            applies every pending migration
+//	migrate to <version>  applies or reverts migrations to reach version
+func (b *PostgresBackend) MigrateCommand(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		args = []string{"status"}
+	}
+	if err := b.Open(); err != nil {
+		return err
+	}
+	defer b.DB.Close()
+
+	switch args[0] {
+	case "status":
+	case "up":
+		if err := b.MigrateUp(); err != nil {
+			return err
+		}
+	case "to":
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		if err := b.MigrateTo(version); err != nil {
+			return err
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	status, err := b.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	for _, s := range status {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(out, "%04d %-20s %s\n", s.Version, s.Name, applied)
+	}
+	return nil
+}
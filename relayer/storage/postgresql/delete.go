@@ -1,6 +1,12 @@
 package postgresql
 
+import "context"
+
 func (b PostgresBackend) DeleteEvent(id string, pubkey string) error {
-	_, err := b.DB.Exec("DELETE FROM event WHERE id = $1 AND pubkey = $2", id, pubkey)
+	return b.DeleteEventCtx(context.Background(), id, pubkey)
+}
+
+func (b PostgresBackend) DeleteEventCtx(ctx context.Context, id string, pubkey string) error {
+	_, err := b.DB.ExecContext(ctx, "DELETE FROM event WHERE id = $1 AND pubkey = $2", id, pubkey)
 	return err
 }
@@ -0,0 +1,229 @@
+package postgresql
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations are the files in the migrations directory, named
+// "<version>_<name>.up.sql" with an optional "<version>_<name>.down.sql"
+// undoing them. Versions are applied in order and never edited once released.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLock is the advisory lock serializing the migrations of
+// hubs and peers sharing a database.
+const migrationLock = 88442
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	// nil when the migration is not applied
+	AppliedAt *time.Time
+}
+
+// Migrations returns the embedded migrations, ordered by version.
+func Migrations() ([]Migration, error) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, path := range names {
+		file := strings.TrimPrefix(path, "migrations/")
+		base, direction := strings.TrimSuffix(file, ".sql"), ""
+		switch {
+		case strings.HasSuffix(base, ".up"):
+			base, direction = strings.TrimSuffix(base, ".up"), "up"
+		case strings.HasSuffix(base, ".down"):
+			base, direction = strings.TrimSuffix(base, ".down"), "down"
+		default:
+			return nil, fmt.Errorf("migration %s is neither .up.sql nor .down.sql", file)
+		}
+		v, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(v)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("migration %s has no version", file)
+		}
+
+		b, err := migrationFiles.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func (b *PostgresBackend) createMigrationsTable() error {
+	_, err := b.DB.Exec(`
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version integer PRIMARY KEY,
+  name text NOT NULL,
+  applied_at timestamptz NOT NULL DEFAULT now()
+);
+    `)
+	return err
+}
+
+// MigrationStatus lists every migration and when it was applied.
+func (b *PostgresBackend) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := b.createMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]time.Time)
+	rows, err := b.DB.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		status[i].Migration = m
+		if at, ok := applied[m.Version]; ok {
+			status[i].AppliedAt = &at
+		}
+	}
+	return status, nil
+}
+
+// MigrateUp applies every pending migration.
+func (b *PostgresBackend) MigrateUp() error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	return b.MigrateTo(migrations[len(migrations)-1].Version)
+}
+
+// MigrateTo applies the migrations up to version, or reverts the ones above it.
+// version is one of the migrations, or 0 to revert them all. Each migration
+// runs in its own transaction.
+func (b *PostgresBackend) MigrateTo(version int) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	known := version == 0
+	for _, m := range migrations {
+		known = known || m.Version == version
+	}
+	if !known {
+		return fmt.Errorf("unknown migration version %d, the latest is %d", version, migrations[len(migrations)-1].Version)
+	}
+
+	status, err := b.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range status {
+		if s.Version <= version && s.AppliedAt == nil {
+			if err := b.runMigration(s.Migration, true); err != nil {
+				return err
+			}
+		}
+	}
+	for i := len(status) - 1; i >= 0; i-- {
+		s := status[i]
+		if s.Version > version && s.AppliedAt != nil {
+			if s.Down == "" {
+				return fmt.Errorf("migration %d_%s can't be reverted", s.Version, s.Name)
+			}
+			if err := b.runMigration(s.Migration, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *PostgresBackend) runMigration(m Migration, up bool) error {
+	tx, err := b.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLock); err != nil {
+		return err
+	}
+	// someone else may have got there first
+	var applied bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, m.Version).Scan(&applied)
+	if err != nil {
+		return err
+	}
+	if applied == up {
+		return nil
+	}
+
+	if up {
+		_, err = tx.Exec(m.Up)
+	} else {
+		_, err = tx.Exec(m.Down)
+	}
+	if err != nil {
+		return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+	}
+
+	if up {
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+	} else {
+		_, err = tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,55 @@
+package postgresql
+
+import "testing"
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d_%s: versions must be sequential from 1, want %d", m.Version, m.Name, i+1)
+		}
+		if m.Name == "" || m.Up == "" {
+			t.Errorf("migration %d is incomplete: %+v", m.Version, m)
+		}
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	b := testBackend(t)
+
+	if err := b.MigrateTo(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	status, err := b.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range status {
+		if s.AppliedAt == nil {
+			t.Errorf("migration %d_%s is not applied", s.Version, s.Name)
+		}
+	}
+}
+
+func TestMigrateToUnknownVersion(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rejected before the database is used
+	b := &PostgresBackend{}
+	for _, version := range []int{-1, migrations[len(migrations)-1].Version + 1} {
+		if err := b.MigrateTo(version); err == nil {
+			t.Errorf("MigrateTo(%d) succeeded", version)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS parameterizedidx;
+ALTER TABLE event DROP COLUMN IF EXISTS dtag;
+DROP FUNCTION IF EXISTS tags_to_dtag(jsonb);
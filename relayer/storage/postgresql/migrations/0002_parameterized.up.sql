@@ -0,0 +1,9 @@
+-- the "d" tag of parameterized replaceable events, see NIP-33
+CREATE OR REPLACE FUNCTION tags_to_dtag(jsonb) RETURNS text
+    AS 'SELECT coalesce((SELECT t->>1 FROM jsonb_array_elements($1) AS t WHERE t->>0 = ''d'' LIMIT 1), '''')'
+    LANGUAGE SQL
+    IMMUTABLE
+    RETURNS NULL ON NULL INPUT;
+
+ALTER TABLE event ADD COLUMN IF NOT EXISTS dtag text GENERATED ALWAYS AS (tags_to_dtag(tags)) STORED;
+CREATE INDEX IF NOT EXISTS parameterizedidx ON event (pubkey, kind, dtag) WHERE kind >= 30000 AND kind < 40000;
@@ -0,0 +1,6 @@
+ALTER TABLE event ADD COLUMN IF NOT EXISTS tagvalues text[] GENERATED ALWAYS AS (tags_to_tagvalues(tags)) STORED;
+CREATE INDEX IF NOT EXISTS arbitrarytagvalues ON event USING gin (tagvalues);
+
+DROP INDEX IF EXISTS tagpairsidx;
+ALTER TABLE event DROP COLUMN IF EXISTS tagpairs;
+DROP FUNCTION IF EXISTS tags_to_tagpairs(jsonb);
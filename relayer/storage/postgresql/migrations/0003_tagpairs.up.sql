@@ -0,0 +1,13 @@
+-- "name:value" of the single-letter tags, computed for the existing rows too
+CREATE OR REPLACE FUNCTION tags_to_tagpairs(jsonb) RETURNS text[]
+    AS 'SELECT array_agg((t->>0) || '':'' || (t->>1)) FROM (SELECT jsonb_array_elements($1) AS t)s WHERE length(t->>0) = 1 AND t->>1 IS NOT NULL;'
+    LANGUAGE SQL
+    IMMUTABLE
+    RETURNS NULL ON NULL INPUT;
+
+ALTER TABLE event ADD COLUMN IF NOT EXISTS tagpairs text[] GENERATED ALWAYS AS (tags_to_tagpairs(tags)) STORED;
+CREATE INDEX IF NOT EXISTS tagpairsidx ON event USING gin (tagpairs);
+
+-- the queries match tagpairs only, so the values alone are dead weight
+DROP INDEX IF EXISTS arbitrarytagvalues;
+ALTER TABLE event DROP COLUMN IF EXISTS tagvalues;
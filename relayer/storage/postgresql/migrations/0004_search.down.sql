@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS contentsearchidx;
+ALTER TABLE event DROP COLUMN IF EXISTS content_search;
@@ -0,0 +1,4 @@
+-- NIP-50 full-text search over the content, direct messages are left out
+ALTER TABLE event ADD COLUMN IF NOT EXISTS content_search tsvector
+    GENERATED ALWAYS AS (to_tsvector('simple', CASE WHEN kind = 4 THEN '' ELSE content END)) STORED;
+CREATE INDEX IF NOT EXISTS contentsearchidx ON event USING gin (content_search);
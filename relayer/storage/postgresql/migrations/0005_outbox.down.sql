@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS outboxpubkeyidx;
+DROP TABLE IF EXISTS outbox;
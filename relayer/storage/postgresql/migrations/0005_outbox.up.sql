@@ -0,0 +1,13 @@
+-- events the hub holds for offline peers, delivered is the peers that got them
+CREATE TABLE IF NOT EXISTS outbox (
+  seq bigserial PRIMARY KEY,
+  pubkey text NOT NULL,
+  event jsonb NOT NULL,
+  attempts integer NOT NULL DEFAULT 0,
+  last_error text NOT NULL DEFAULT '',
+  dead boolean NOT NULL DEFAULT false,
+  delivered text[] NOT NULL DEFAULT '{}',
+  created_at integer NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS outboxpubkeyidx ON outbox (pubkey, seq) WHERE NOT dead;
@@ -1,18 +1,34 @@
 package postgresql
 
 import (
-	"github.com/jmoiron/sqlx"
 	"time"
-)
 
-type PeerInfo struct {
-	Address    string
-	LastUpdate time.Time
-}
+	"github.com/jmoiron/sqlx"
+	"github.com/sithumonline/demedia-nostr/relayer/registry"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
+)
 
 type PostgresBackend struct {
 	*sqlx.DB
 	DatabaseURL string
-	Map         map[string]PeerInfo
 	ServiceName string
+
+	// PeerTTL is how long a registered peer is kept without a ping,
+	// defaults to registry.DefaultTTL.
+	PeerTTL time.Duration
+
+	// Limits bound the events returned for a filter.
+	Limits storage.QueryLimits
+
+	peers *registry.Registry
+}
+
+// Peers returns the peer registry, available after Init.
+func (b *PostgresBackend) Peers() *registry.Registry {
+	return b.peers
+}
+
+// SetQueryLimits sets Limits, see relayer.QueryLimiter.
+func (b *PostgresBackend) SetQueryLimits(limits storage.QueryLimits) {
+	b.Limits = limits
 }
@@ -1,27 +1,35 @@
 package postgresql
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
-	"time"
+	"fmt"
+	"log"
 
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/sithumonline/demedia-nostr/relayer/storage"
 )
 
 func (b *PostgresBackend) SaveEvent(evt *nostr.Event) error {
+	return b.SaveEventCtx(context.Background(), evt)
+}
+
+func (b *PostgresBackend) SaveEventCtx(ctx context.Context, evt *nostr.Event) error {
 	// react to different kinds of events
 	if evt.Kind == nostr.KindSetMetadata || evt.Kind == nostr.KindContactList || (10000 <= evt.Kind && evt.Kind < 20000) {
-		// delete past events from this user
-		b.DB.Exec(`DELETE FROM event WHERE pubkey = $1 AND kind = $2`, evt.PubKey, evt.Kind)
+		return b.saveReplaceable(ctx, evt, false)
 	} else if evt.Kind == nostr.KindRecommendServer {
 		// delete past recommend_server events equal to this one
-		b.DB.Exec(`DELETE FROM event WHERE pubkey = $1 AND kind = $2 AND content = $3`,
+		b.DB.ExecContext(ctx, `DELETE FROM event WHERE pubkey = $1 AND kind = $2 AND content = $3`,
 			evt.PubKey, evt.Kind, evt.Content)
+	} else if 30000 <= evt.Kind && evt.Kind < 40000 {
+		return b.saveReplaceable(ctx, evt, true)
 	}
 
 	// insert
 	tagsj, _ := json.Marshal(evt.Tags)
-	res, err := b.DB.Exec(`
+	res, err := b.DB.ExecContext(ctx, `
         INSERT INTO event (id, pubkey, created_at, kind, tags, content, sig)
         VALUES ($1, $2, $3, $4, $5, $6, $7)
 		ON CONFLICT (id) DO NOTHING
@@ -42,6 +50,65 @@ func (b *PostgresBackend) SaveEvent(evt *nostr.Event) error {
 	return nil
 }
 
+// saveReplaceable replaces the stored version of a replaceable event (NIP-16),
+// unless it is newer than evt. Events with the same pubkey and kind are versions
+// of each other, and when parameterized (NIP-33) they also need the same "d" tag,
+// a missing "d" tag counting as "".
+func (b *PostgresBackend) saveReplaceable(ctx context.Context, evt *nostr.Event, parameterized bool) error {
+	versions, params := `pubkey = $1 AND kind = $2`, []any{evt.PubKey, evt.Kind}
+	d := ""
+	if parameterized {
+		if tag := evt.Tags.GetFirst([]string{"d", ""}); tag != nil {
+			d = tag.Value()
+		}
+		versions += ` AND dtag = $3`
+		params = append(params, d)
+	}
+	tagsj, _ := json.Marshal(evt.Tags)
+
+	tx, err := b.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// serialize the writers of the same event so that the newer one always wins
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`,
+		fmt.Sprintf("%s:%d:%s", evt.PubKey, evt.Kind, d))
+	if err != nil {
+		return err
+	}
+
+	var id string
+	var createdAt int64
+	err = tx.QueryRowContext(ctx, `SELECT id, created_at FROM event WHERE `+versions+`
+		ORDER BY created_at DESC, id LIMIT 1`, params...).Scan(&id, &createdAt)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return err
+	case id == evt.ID:
+		return storage.ErrDupEvent
+	case createdAt > evt.CreatedAt.Unix() || (createdAt == evt.CreatedAt.Unix() && id < evt.ID):
+		// on equal timestamps the lowest id wins
+		return storage.ErrOlderEvent
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM event WHERE `+versions, params...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO event (id, pubkey, created_at, kind, tags, content, sig)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+    `, evt.ID, evt.PubKey, evt.CreatedAt.Unix(), evt.Kind, tagsj, evt.Content, evt.Sig)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (b *PostgresBackend) BeforeSave(evt *nostr.Event) {
 	// do nothing
 }
@@ -49,14 +116,13 @@ func (b *PostgresBackend) BeforeSave(evt *nostr.Event) {
 func (b *PostgresBackend) AfterSave(evt *nostr.Event) {
 	// delete all but the 100 most recent ones for each key
 	b.DB.Exec(`DELETE FROM event WHERE pubkey = $1 AND kind = $2 AND created_at < (
-      SELECT created_at FROM event WHERE pubkey = $1
+      SELECT created_at FROM event WHERE pubkey = $1 AND kind = $2
       ORDER BY created_at DESC OFFSET 100 LIMIT 1
     )`, evt.PubKey, evt.Kind)
 }
 
 func (b *PostgresBackend) SavePeer(address string, pubkey string) {
-	b.Map[pubkey] = PeerInfo{
-		Address:    address,
-		LastUpdate: time.Now(),
+	if err := b.peers.Save(address, pubkey); err != nil {
+		log.Printf("failed to persist peer %s: %v", pubkey, err)
 	}
 }
@@ -0,0 +1,98 @@
+package postgresql
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
+)
+
+// testBackend connects to the database in TEST_POSTGRESQL_DATABASE, the tests
+// that need one are skipped when it is not set.
+func testBackend(t *testing.T) *PostgresBackend {
+	url := os.Getenv("TEST_POSTGRESQL_DATABASE")
+	if url == "" {
+		t.Skip("TEST_POSTGRESQL_DATABASE is not set")
+	}
+	b := &PostgresBackend{DatabaseURL: url, ServiceName: "test"}
+	if err := b.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		b.DB.Exec(`DELETE FROM event WHERE pubkey IN ('nip33', 'tags')`)
+		b.DB.Close()
+	})
+	return b
+}
+
+func TestSaveParameterizedReplaceable(t *testing.T) {
+	b := testBackend(t)
+
+	version := func(id string, d string, createdAt int64) *nostr.Event {
+		evt := &nostr.Event{
+			ID:        id,
+			PubKey:    "nip33",
+			Kind:      30023,
+			CreatedAt: time.Unix(createdAt, 0),
+		}
+		if d != "-" {
+			evt.Tags = nostr.Tags{{"d", d}}
+		}
+		return evt
+	}
+	stored := func(d string) []string {
+		events, err := b.QueryEvents(&nostr.Filter{Kinds: []int{30023}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, evt := range events {
+			tag := evt.Tags.GetFirst([]string{"d", ""})
+			if evt.PubKey == "nip33" && ((tag == nil && d == "") || (tag != nil && tag.Value() == d)) {
+				ids = append(ids, evt.ID)
+			}
+		}
+		return ids
+	}
+
+	if err := b.SaveEvent(version("a1", "article", 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SaveEvent(version("a2", "article", 200)); err != nil {
+		t.Fatal(err)
+	}
+	if ids := stored("article"); len(ids) != 1 || ids[0] != "a2" {
+		t.Errorf("newer version did not replace the older one: %v", ids)
+	}
+
+	if err := b.SaveEvent(version("a0", "article", 50)); err != storage.ErrOlderEvent {
+		t.Errorf("saving an older version returned %v", err)
+	}
+	if err := b.SaveEvent(version("a2", "article", 200)); err != storage.ErrDupEvent {
+		t.Errorf("saving the same version returned %v", err)
+	}
+	if ids := stored("article"); len(ids) != 1 || ids[0] != "a2" {
+		t.Errorf("older version replaced the newer one: %v", ids)
+	}
+
+	// other "d" tags are other events
+	if err := b.SaveEvent(version("b1", "list", 100)); err != nil {
+		t.Fatal(err)
+	}
+	if ids := stored("article"); len(ids) != 1 {
+		t.Errorf("an event with another d tag replaced the article: %v", ids)
+	}
+
+	// a missing "d" tag is the same as an empty one
+	if err := b.SaveEvent(version("c1", "-", 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SaveEvent(version("c2", "", 200)); err != nil {
+		t.Fatal(err)
+	}
+	if ids := stored(""); len(ids) != 1 || ids[0] != "c2" {
+		t.Errorf("empty d tag did not replace the missing one: %v", ids)
+	}
+}
@@ -0,0 +1,64 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
+	"github.com/sithumonline/demedia-nostr/relayer/storage/sqlstore"
+)
+
+// SearchEvents runs a NIP-50 search, ranking the events matching filter by the
+// relevance of their content to search. search takes the syntax of
+// websearch_to_tsquery: words, "quoted phrases", or and -excluded words.
+func (b PostgresBackend) SearchEvents(ctx context.Context, filter *nostr.Filter, search string) ([]storage.SearchResult, error) {
+	if filter == nil {
+		return nil, errors.New("filter cannot be null")
+	}
+
+	conditions, params, ok := sqlstore.FilterConditions(filter, tagCondition)
+	if !ok {
+		return nil, nil
+	}
+	conditions = append(conditions, "content_search @@ websearch_to_tsquery('simple', ?)")
+	// the search is also ranked in the SELECT, before the conditions
+	params = append([]any{search}, append(params, search)...)
+
+	if filter.Limit < 1 || filter.Limit > b.Limits.Queried() {
+		params = append(params, b.Limits.Queried())
+	} else {
+		params = append(params, filter.Limit)
+	}
+
+	query := b.DB.Rebind(`SELECT
+      id, pubkey, created_at, kind, tags, content, sig,
+      ts_rank(content_search, websearch_to_tsquery('simple', ?)) AS rank
+    FROM event WHERE ` +
+		strings.Join(conditions, " AND ") +
+		" ORDER BY rank DESC, created_at DESC LIMIT ?")
+
+	rows, err := b.DB.QueryContext(ctx, query, params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search events using query %q: %w", query, err)
+	}
+	defer rows.Close()
+
+	var results []storage.SearchResult
+	for rows.Next() {
+		var r storage.SearchResult
+		var timestamp int64
+		err := rows.Scan(&r.Event.ID, &r.Event.PubKey, &timestamp,
+			&r.Event.Kind, &r.Event.Tags, &r.Event.Content, &r.Event.Sig, &r.Score)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		r.Event.CreatedAt = time.Unix(timestamp, 0)
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
@@ -0,0 +1,20 @@
+package postgresql
+
+import (
+	"testing"
+
+	"github.com/sithumonline/demedia-nostr/relayer"
+	"github.com/sithumonline/demedia-nostr/relayer/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) relayer.Storage {
+		b := testBackend(t)
+		// runs before the database is closed
+		t.Cleanup(func() {
+			b.DB.Exec(`DELETE FROM event WHERE pubkey LIKE '%' || $1`, storagetest.PubKeySuffix)
+			b.DB.Exec(`DELETE FROM peer WHERE pubkey LIKE '%' || $1`, storagetest.PubKeySuffix)
+		})
+		return b
+	})
+}
@@ -0,0 +1,11 @@
+package storage
+
+import "github.com/nbd-wtf/go-nostr"
+
+// SearchResult is an event found by a full-text search (NIP-50) with its
+// relevance, higher is better. Scores are only comparable between storages of
+// the same kind.
+type SearchResult struct {
+	Event nostr.Event
+	Score float64
+}
@@ -0,0 +1,12 @@
+package sqlite
+
+import "context"
+
+func (b SQLiteBackend) DeleteEvent(id string, pubkey string) error {
+	return b.DeleteEventCtx(context.Background(), id, pubkey)
+}
+
+func (b SQLiteBackend) DeleteEventCtx(ctx context.Context, id string, pubkey string) error {
+	_, err := b.DB.ExecContext(ctx, "DELETE FROM event WHERE id = ? AND pubkey = ?", id, pubkey)
+	return err
+}
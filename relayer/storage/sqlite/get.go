@@ -0,0 +1,13 @@
+package sqlite
+
+func (b *SQLiteBackend) GetPeer(pubkey string) string {
+	return b.peers.Get(pubkey)
+}
+
+func (b *SQLiteBackend) GetPeers(pubkey string, n int) []string {
+	return b.peers.Lookup(pubkey, n)
+}
+
+func (b *SQLiteBackend) GetAllPeers() []string {
+	return b.peers.Addresses()
+}
@@ -0,0 +1,61 @@
+package sqlite
+
+import (
+	_ "modernc.org/sqlite"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/reflectx"
+	"github.com/sithumonline/demedia-nostr/relayer/registry"
+	"github.com/sithumonline/demedia-nostr/relayer/storage/sqlstore"
+	"github.com/uptrace/opentelemetry-go-extra/otelsql"
+	"github.com/uptrace/opentelemetry-go-extra/otelsqlx"
+)
+
+// WAL lets the queries run while an event is written, and writers wait for
+// each other instead of failing with SQLITE_BUSY. Transactions take the write
+// lock upfront so that the read-then-write of saveParameterized can't deadlock.
+const pragmas = "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=synchronous(NORMAL)&_txlock=immediate"
+
+func (b *SQLiteBackend) Init() error {
+	db, err := otelsqlx.Open("sqlite", "file:"+b.Path+pragmas, otelsql.WithDBName(b.ServiceName+"db"))
+	if err != nil {
+		return err
+	}
+
+	db.Mapper = reflectx.NewMapperFunc("json", sqlx.NameMapper)
+	b.DB = db
+
+	_, err = b.DB.Exec(`
+CREATE TABLE IF NOT EXISTS event (
+  id text NOT NULL PRIMARY KEY,
+  pubkey text NOT NULL,
+  created_at integer NOT NULL,
+  kind integer NOT NULL,
+  tags text NOT NULL,
+  content text NOT NULL,
+  sig text NOT NULL,
+
+  -- the "d" tag of parameterized replaceable events, see NIP-33
+  dtag text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS pubkeyprefix ON event (pubkey);
+CREATE INDEX IF NOT EXISTS timeidx ON event (created_at DESC);
+CREATE INDEX IF NOT EXISTS kindidx ON event (kind);
+CREATE INDEX IF NOT EXISTS parameterizedidx ON event (pubkey, kind, dtag) WHERE kind >= 30000 AND kind < 40000;
+
+CREATE TABLE IF NOT EXISTS peer (
+  pubkey text NOT NULL,
+  address text NOT NULL,
+  last_update integer NOT NULL,
+
+  PRIMARY KEY (pubkey, address)
+);
+    `)
+	if err != nil {
+		return err
+	}
+
+	b.peers = registry.New(sqlstore.PeerTable{DB: b.DB}, b.PeerTTL)
+	return b.peers.Load()
+}
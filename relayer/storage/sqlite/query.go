@@ -0,0 +1,47 @@
+package sqlite
+
+import (
+	"context"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/storage/sqlstore"
+)
+
+func (b SQLiteBackend) QueryEvents(filter *nostr.Filter) ([]nostr.Event, error) {
+	return b.QueryEventsCtx(context.Background(), filter)
+}
+
+func (b SQLiteBackend) QueryEventsCtx(ctx context.Context, filter *nostr.Filter) (events []nostr.Event, err error) {
+	err = sqlstore.QueryEvents(ctx, b.DB, filter, tagCondition, b.Limits.Queried(), func(evt nostr.Event) error {
+		events = append(events, evt)
+		return nil
+	})
+	return events, err
+}
+
+func (b SQLiteBackend) StreamEvents(ctx context.Context, filter *nostr.Filter, send func(nostr.Event) error) error {
+	return sqlstore.QueryEvents(ctx, b.DB, filter, tagCondition, b.Limits.Streamed(), send)
+}
+
+// CountEvents counts the events matching filter, regardless of its limit (NIP-45).
+func (b SQLiteBackend) CountEvents(ctx context.Context, filter *nostr.Filter) (int64, error) {
+	return sqlstore.CountEvents(ctx, b.DB, filter, tagCondition)
+}
+
+// tagCondition matches the tags in the JSON of the events, only single-letter
+// tags are queryable.
+func tagCondition(name string, values []string) (string, []any, bool) {
+	if len(name) != 1 {
+		return "", nil, false
+	}
+
+	arrayBuild := make([]string, len(values))
+	params := []any{name}
+	for i, value := range values {
+		arrayBuild[i] = "?"
+		params = append(params, value)
+	}
+	return `EXISTS (SELECT 1 FROM json_each(event.tags)
+		  WHERE json_extract(value, '$[0]') = ? AND json_extract(value, '$[1]') IN (` + strings.Join(arrayBuild, ",") + `))`, params, true
+}
@@ -0,0 +1,61 @@
+package sqlite
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestQueryTags(t *testing.T) {
+	b := testBackend(t)
+
+	for _, evt := range []nostr.Event{
+		{ID: "t1", Tags: nostr.Tags{{"e", "x"}}},
+		{ID: "t2", Tags: nostr.Tags{{"p", "x"}}},
+		{ID: "t3", Tags: nostr.Tags{{"e", "x"}, {"p", "y"}}},
+		{ID: "t4", Tags: nostr.Tags{{"e", "z"}, {"p", "y"}}},
+		{ID: "t5", Tags: nostr.Tags{{"emoji", "x"}}},
+	} {
+		evt.PubKey = "tags"
+		evt.Kind = 1
+		evt.CreatedAt = time.Unix(100, 0)
+		if err := b.SaveEvent(&evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		tags nostr.TagMap
+		want []string
+	}{
+		{nostr.TagMap{"e": {"x"}}, []string{"t1", "t3"}},
+		{nostr.TagMap{"p": {"x"}}, []string{"t2"}},
+		{nostr.TagMap{"e": {"x", "z"}}, []string{"t1", "t3", "t4"}},
+		{nostr.TagMap{"e": {"x", "z"}, "p": {"y"}}, []string{"t3", "t4"}},
+		{nostr.TagMap{"e": {"x"}, "p": {"x"}}, nil},
+	} {
+		events, err := b.QueryEvents(&nostr.Filter{Tags: tc.tags})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, evt := range events {
+			if evt.PubKey == "tags" {
+				ids = append(ids, evt.ID)
+			}
+		}
+		sort.Strings(ids)
+		if len(ids) != len(tc.want) {
+			t.Errorf("%v: got %v, want %v", tc.tags, ids, tc.want)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tc.want[i] {
+				t.Errorf("%v: got %v, want %v", tc.tags, ids, tc.want)
+				break
+			}
+		}
+	}
+}
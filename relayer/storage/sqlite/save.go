@@ -0,0 +1,122 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
+)
+
+func (b *SQLiteBackend) SaveEvent(evt *nostr.Event) error {
+	return b.SaveEventCtx(context.Background(), evt)
+}
+
+func (b *SQLiteBackend) SaveEventCtx(ctx context.Context, evt *nostr.Event) error {
+	// react to different kinds of events
+	if evt.Kind == nostr.KindSetMetadata || evt.Kind == nostr.KindContactList || (10000 <= evt.Kind && evt.Kind < 20000) {
+		return b.saveReplaceable(ctx, evt, false)
+	} else if evt.Kind == nostr.KindRecommendServer {
+		// delete past recommend_server events equal to this one
+		b.DB.ExecContext(ctx, `DELETE FROM event WHERE pubkey = ? AND kind = ? AND content = ?`,
+			evt.PubKey, evt.Kind, evt.Content)
+	} else if 30000 <= evt.Kind && evt.Kind < 40000 {
+		return b.saveReplaceable(ctx, evt, true)
+	}
+
+	// insert
+	tagsj, _ := json.Marshal(evt.Tags)
+	res, err := b.DB.ExecContext(ctx, `
+        INSERT INTO event (id, pubkey, created_at, kind, tags, content, sig)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO NOTHING
+    `, evt.ID, evt.PubKey, evt.CreatedAt.Unix(), evt.Kind, string(tagsj), evt.Content, evt.Sig)
+	if err != nil {
+		return err
+	}
+
+	nr, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if nr == 0 {
+		return storage.ErrDupEvent
+	}
+
+	return nil
+}
+
+// saveReplaceable replaces the stored version of a replaceable event (NIP-16),
+// unless it is newer than evt. Events with the same pubkey and kind are versions
+// of each other, and when parameterized (NIP-33) they also need the same "d" tag,
+// a missing "d" tag counting as "".
+func (b *SQLiteBackend) saveReplaceable(ctx context.Context, evt *nostr.Event, parameterized bool) error {
+	versions, params := `pubkey = ? AND kind = ?`, []any{evt.PubKey, evt.Kind}
+	d := ""
+	if parameterized {
+		if tag := evt.Tags.GetFirst([]string{"d", ""}); tag != nil {
+			d = tag.Value()
+		}
+		versions += ` AND dtag = ?`
+		params = append(params, d)
+	}
+	tagsj, _ := json.Marshal(evt.Tags)
+
+	// the transaction holds the write lock of the whole database, so the
+	// writers of the same event are serialized and the newer one always wins
+	tx, err := b.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id string
+	var createdAt int64
+	err = tx.QueryRowContext(ctx, `SELECT id, created_at FROM event WHERE `+versions+`
+		ORDER BY created_at DESC, id LIMIT 1`, params...).Scan(&id, &createdAt)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return err
+	case id == evt.ID:
+		return storage.ErrDupEvent
+	case createdAt > evt.CreatedAt.Unix() || (createdAt == evt.CreatedAt.Unix() && id < evt.ID):
+		// on equal timestamps the lowest id wins
+		return storage.ErrOlderEvent
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM event WHERE `+versions, params...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO event (id, pubkey, created_at, kind, tags, content, sig, dtag)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+    `, evt.ID, evt.PubKey, evt.CreatedAt.Unix(), evt.Kind, string(tagsj), evt.Content, evt.Sig, d)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (b *SQLiteBackend) BeforeSave(evt *nostr.Event) {
+	// do nothing
+}
+
+func (b *SQLiteBackend) AfterSave(evt *nostr.Event) {
+	// delete all but the 100 most recent ones for each key
+	b.DB.Exec(`DELETE FROM event WHERE pubkey = ?1 AND kind = ?2 AND created_at < (
+      SELECT created_at FROM event WHERE pubkey = ?1 AND kind = ?2
+      ORDER BY created_at DESC LIMIT 1 OFFSET 100
+    )`, evt.PubKey, evt.Kind)
+}
+
+func (b *SQLiteBackend) SavePeer(address string, pubkey string) {
+	if err := b.peers.Save(address, pubkey); err != nil {
+		log.Printf("failed to persist peer %s: %v", pubkey, err)
+	}
+}
@@ -0,0 +1,118 @@
+package sqlite
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
+)
+
+// testBackend opens a new database in a temporary directory.
+func testBackend(t *testing.T) *SQLiteBackend {
+	b := &SQLiteBackend{Path: filepath.Join(t.TempDir(), "test.db"), ServiceName: "test"}
+	if err := b.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		b.DB.Close()
+	})
+	return b
+}
+
+func TestSaveParameterizedReplaceable(t *testing.T) {
+	b := testBackend(t)
+
+	version := func(id string, d string, createdAt int64) *nostr.Event {
+		evt := &nostr.Event{
+			ID:        id,
+			PubKey:    "nip33",
+			Kind:      30023,
+			CreatedAt: time.Unix(createdAt, 0),
+		}
+		if d != "-" {
+			evt.Tags = nostr.Tags{{"d", d}}
+		}
+		return evt
+	}
+	stored := func(d string) []string {
+		events, err := b.QueryEvents(&nostr.Filter{Kinds: []int{30023}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, evt := range events {
+			tag := evt.Tags.GetFirst([]string{"d", ""})
+			if evt.PubKey == "nip33" && ((tag == nil && d == "") || (tag != nil && tag.Value() == d)) {
+				ids = append(ids, evt.ID)
+			}
+		}
+		return ids
+	}
+
+	if err := b.SaveEvent(version("a1", "article", 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SaveEvent(version("a2", "article", 200)); err != nil {
+		t.Fatal(err)
+	}
+	if ids := stored("article"); len(ids) != 1 || ids[0] != "a2" {
+		t.Errorf("newer version did not replace the older one: %v", ids)
+	}
+
+	if err := b.SaveEvent(version("a0", "article", 50)); err != storage.ErrOlderEvent {
+		t.Errorf("saving an older version returned %v", err)
+	}
+	if err := b.SaveEvent(version("a2", "article", 200)); err != storage.ErrDupEvent {
+		t.Errorf("saving the same version returned %v", err)
+	}
+	if ids := stored("article"); len(ids) != 1 || ids[0] != "a2" {
+		t.Errorf("older version replaced the newer one: %v", ids)
+	}
+
+	// other "d" tags are other events
+	if err := b.SaveEvent(version("b1", "list", 100)); err != nil {
+		t.Fatal(err)
+	}
+	if ids := stored("article"); len(ids) != 1 {
+		t.Errorf("an event with another d tag replaced the article: %v", ids)
+	}
+
+	// a missing "d" tag is the same as an empty one
+	if err := b.SaveEvent(version("c1", "-", 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SaveEvent(version("c2", "", 200)); err != nil {
+		t.Fatal(err)
+	}
+	if ids := stored(""); len(ids) != 1 || ids[0] != "c2" {
+		t.Errorf("empty d tag did not replace the missing one: %v", ids)
+	}
+}
+
+func TestAfterSaveKeepsOtherKinds(t *testing.T) {
+	b := testBackend(t)
+
+	reaction := &nostr.Event{ID: "reaction", PubKey: "busy", Kind: 7, CreatedAt: time.Unix(1, 0)}
+	if err := b.SaveEvent(reaction); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 101; i++ {
+		evt := &nostr.Event{ID: fmt.Sprintf("note%d", i), PubKey: "busy", Kind: 1, CreatedAt: time.Unix(int64(100+i), 0)}
+		if err := b.SaveEvent(evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the notes are not the reactions' 100 most recent ones
+	b.AfterSave(reaction)
+	events, err := b.QueryEvents(&nostr.Filter{Kinds: []int{7}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Error("AfterSave deleted the only event of its kind")
+	}
+}
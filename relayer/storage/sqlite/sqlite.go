@@ -0,0 +1,37 @@
+package sqlite
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sithumonline/demedia-nostr/relayer/registry"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
+)
+
+// SQLiteBackend stores the events in a single SQLite file, for peers that
+// don't want to run a database server. It uses a pure Go driver, so it
+// builds without cgo.
+type SQLiteBackend struct {
+	*sqlx.DB
+	Path        string
+	ServiceName string
+
+	// PeerTTL is how long a registered peer is kept without a ping,
+	// defaults to registry.DefaultTTL.
+	PeerTTL time.Duration
+
+	// Limits bound the events returned for a filter.
+	Limits storage.QueryLimits
+
+	peers *registry.Registry
+}
+
+// Peers returns the peer registry, available after Init.
+func (b *SQLiteBackend) Peers() *registry.Registry {
+	return b.peers
+}
+
+// SetQueryLimits sets Limits, see relayer.QueryLimiter.
+func (b *SQLiteBackend) SetQueryLimits(limits storage.QueryLimits) {
+	b.Limits = limits
+}
@@ -0,0 +1,49 @@
+package sqlstore
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sithumonline/demedia-nostr/relayer/registry"
+)
+
+var _ registry.Store = PeerTable{}
+
+// PeerTable persists a peer registry in the peer table of DB.
+type PeerTable struct {
+	DB *sqlx.DB
+}
+
+func (t PeerTable) LoadPeers() ([]registry.Peer, error) {
+	rows, err := t.DB.Query(`SELECT pubkey, address, last_update FROM peer`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var peers []registry.Peer
+	for rows.Next() {
+		var p registry.Peer
+		var lastUpdate int64
+		if err := rows.Scan(&p.PubKey, &p.Address, &lastUpdate); err != nil {
+			return nil, err
+		}
+		p.LastUpdate = time.Unix(lastUpdate, 0)
+		peers = append(peers, p)
+	}
+	return peers, rows.Err()
+}
+
+func (t PeerTable) SavePeer(p registry.Peer) error {
+	_, err := t.DB.Exec(t.DB.Rebind(`
+        INSERT INTO peer (pubkey, address, last_update) VALUES (?, ?, ?)
+		ON CONFLICT (pubkey, address) DO UPDATE SET last_update = excluded.last_update
+    `), p.PubKey, p.Address, p.LastUpdate.Unix())
+	return err
+}
+
+func (t PeerTable) DeletePeer(pubkey string, address string, before time.Time) error {
+	_, err := t.DB.Exec(t.DB.Rebind(`DELETE FROM peer WHERE pubkey = ? AND address = ? AND last_update <= ?`),
+		pubkey, address, before.Unix())
+	return err
+}
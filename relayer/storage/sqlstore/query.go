@@ -0,0 +1,194 @@
+// Package sqlstore holds what the SQL storages share: the translation of
+// filters to conditions, the queries built on them and the peer table.
+// Queries are written with ? placeholders, rebound for the driver of the
+// database.
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
+)
+
+// TagCondition returns the condition matching the events with the tag name set
+// to any of values, and its parameters. ok is false when no event can match.
+type TagCondition func(name string, values []string) (condition string, params []any, ok bool)
+
+// QueryEvents sends the events of db matching filter, newest first, at most
+// maxLimit of them whatever the limit of filter.
+func QueryEvents(ctx context.Context, db *sqlx.DB, filter *nostr.Filter, tag TagCondition, maxLimit int, send func(nostr.Event) error) error {
+	if filter == nil {
+		return errors.New("filter cannot be null")
+	}
+
+	conditions, params, ok := FilterConditions(filter, tag)
+	if !ok {
+		return nil
+	}
+
+	if filter.Limit < 1 || filter.Limit > maxLimit {
+		params = append(params, maxLimit)
+	} else {
+		params = append(params, filter.Limit)
+	}
+
+	query := db.Rebind(`SELECT
+      id, pubkey, created_at, kind, tags, content, sig
+    FROM event WHERE ` +
+		strings.Join(conditions, " AND ") +
+		" ORDER BY created_at DESC LIMIT ?")
+
+	rows, err := db.QueryContext(ctx, query, params...)
+	if err != nil {
+		return fmt.Errorf("failed to fetch events using query %q: %w", query, err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var evt nostr.Event
+		var timestamp int64
+		err := rows.Scan(&evt.ID, &evt.PubKey, &timestamp,
+			&evt.Kind, &evt.Tags, &evt.Content, &evt.Sig)
+		if err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		evt.CreatedAt = time.Unix(timestamp, 0)
+		if err := send(evt); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// CountEvents counts the events of db matching filter, regardless of its limit.
+func CountEvents(ctx context.Context, db *sqlx.DB, filter *nostr.Filter, tag TagCondition) (int64, error) {
+	if filter == nil {
+		return 0, errors.New("filter cannot be null")
+	}
+
+	conditions, params, ok := FilterConditions(filter, tag)
+	if !ok {
+		return 0, nil
+	}
+
+	query := db.Rebind(`SELECT COUNT(*) FROM event WHERE ` + strings.Join(conditions, " AND "))
+	var count int64
+	if err := db.QueryRowContext(ctx, query, params...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count events using query %q: %w", query, err)
+	}
+	return count, nil
+}
+
+// FilterConditions translates filter to SQL conditions, to be AND-ed, and their
+// parameters, the tags going through tag. ok is false when nothing can match
+// filter.
+func FilterConditions(filter *nostr.Filter, tag TagCondition) (conditions []string, params []any, ok bool) {
+	if filter.IDs != nil {
+		if len(filter.IDs) > 500 {
+			// too many ids, fail everything
+			return nil, nil, false
+		}
+
+		likeids := make([]string, 0, len(filter.IDs))
+		for _, id := range filter.IDs {
+			// to prevent sql attack here we will check if
+			// these ids are valid hex prefixes
+			if !storage.IsHexPrefix(id) {
+				continue
+			}
+			likeids = append(likeids, fmt.Sprintf("id LIKE '%s%%'", id))
+		}
+		if len(likeids) == 0 {
+			// ids being [] mean you won't get anything
+			return nil, nil, false
+		}
+		conditions = append(conditions, "("+strings.Join(likeids, " OR ")+")")
+	}
+
+	if filter.Authors != nil {
+		if len(filter.Authors) > 500 {
+			// too many authors, fail everything
+			return nil, nil, false
+		}
+
+		likekeys := make([]string, 0, len(filter.Authors))
+		for _, key := range filter.Authors {
+			// to prevent sql attack here we will check if
+			// these keys are valid hex prefixes
+			if !storage.IsHexPrefix(key) {
+				continue
+			}
+			likekeys = append(likekeys, fmt.Sprintf("pubkey LIKE '%s%%'", key))
+		}
+		if len(likekeys) == 0 {
+			// authors being [] mean you won't get anything
+			return nil, nil, false
+		}
+		conditions = append(conditions, "("+strings.Join(likekeys, " OR ")+")")
+	}
+
+	if filter.Kinds != nil {
+		if len(filter.Kinds) > 10 {
+			// too many kinds, fail everything
+			return nil, nil, false
+		}
+
+		if len(filter.Kinds) == 0 {
+			// kinds being [] mean you won't get anything
+			return nil, nil, false
+		}
+		// no sql injection issues since these are ints
+		inkinds := make([]string, len(filter.Kinds))
+		for i, kind := range filter.Kinds {
+			inkinds[i] = strconv.Itoa(kind)
+		}
+		conditions = append(conditions, `kind IN (`+strings.Join(inkinds, ",")+`)`)
+	}
+
+	// values of one tag are OR-ed, different tags are AND-ed
+	tagCount := 0
+	for name, values := range filter.Tags {
+		if len(values) == 0 {
+			// any tag set to [] is wrong
+			return nil, nil, false
+		}
+
+		tagCount += len(values)
+		if tagCount > 10 {
+			// too many tags, fail everything
+			return nil, nil, false
+		}
+
+		condition, tagParams, ok := tag(name, values)
+		if !ok {
+			return nil, nil, false
+		}
+		conditions = append(conditions, condition)
+		params = append(params, tagParams...)
+	}
+
+	if filter.Since != nil {
+		conditions = append(conditions, "created_at >= ?")
+		params = append(params, filter.Since.Unix())
+	}
+	if filter.Until != nil {
+		conditions = append(conditions, "created_at <= ?")
+		params = append(params, filter.Until.Unix())
+	}
+
+	if len(conditions) == 0 {
+		// fallback
+		conditions = append(conditions, "true")
+	}
+
+	return conditions, params, true
+}
@@ -0,0 +1,336 @@
+// Package storagetest is a behavioural test suite for the implementations of
+// relayer.Storage, so that every backend handles the events the same way.
+//
+// A backend runs it from its own tests:
+//
+//	func TestStorage(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) relayer.Storage {
+//			b := &Backend{...}
+//			if err := b.Init(); err != nil {
+//				t.Fatal(err)
+//			}
+//			return b
+//		})
+//	}
+package storagetest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sithumonline/demedia-nostr/relayer"
+	"github.com/sithumonline/demedia-nostr/relayer/storage"
+)
+
+// Run runs the suite against the storages returned by newStorage, which is
+// called once per test and must return an initialized storage.
+//
+// The storage doesn't need to be empty: the suite publishes with random
+// pubkeys and only looks at its own events, so a shared database works too.
+// These pubkeys end with PubKeySuffix, for the storage to delete the events
+// and peers of the suite in a cleanup of newStorage.
+func Run(t *testing.T, newStorage func(t *testing.T) relayer.Storage) {
+	for _, test := range []struct {
+		name string
+		run  func(t *testing.T, s relayer.Storage)
+	}{
+		{"SaveDuplicate", testSaveDuplicate},
+		{"Replaceable", testReplaceable},
+		{"ParameterizedReplaceable", testParameterizedReplaceable},
+		{"Delete", testDelete},
+		{"Filters", testFilters},
+		{"Limit", testLimit},
+		{"Count", testCount},
+		{"Peers", testPeers},
+		{"Prune", testPrune},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, newStorage(t))
+		})
+	}
+}
+
+// PubKeySuffix ends every pubkey the suite publishes with.
+const PubKeySuffix = "5e575e575e575e57"
+
+func randomPubKey(t *testing.T) string {
+	b := make([]byte, 32-len(PubKeySuffix)/2)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(b) + PubKeySuffix
+}
+
+// newEvent makes an event with a unique id, the storages don't check signatures.
+func newEvent(pubkey string, kind int, createdAt int64, tags ...nostr.Tag) *nostr.Event {
+	evt := &nostr.Event{
+		PubKey:    pubkey,
+		Kind:      kind,
+		CreatedAt: time.Unix(createdAt, 0),
+		Tags:      tags,
+		Content:   fmt.Sprintf("%d at %d", kind, createdAt),
+	}
+	id := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%d:%v:%d", pubkey, kind, createdAt, tags, time.Now().UnixNano())))
+	evt.ID = hex.EncodeToString(id[:])
+	return evt
+}
+
+func save(t *testing.T, s relayer.Storage, events ...*nostr.Event) {
+	t.Helper()
+	for _, evt := range events {
+		if err := s.SaveEvent(evt); err != nil {
+			t.Fatalf("saving %s: %v", evt.ID, err)
+		}
+	}
+}
+
+func query(t *testing.T, s relayer.Storage, filter nostr.Filter) []string {
+	t.Helper()
+	events, err := s.QueryEvents(&filter)
+	if err != nil {
+		t.Fatalf("querying %v: %v", filter, err)
+	}
+	ids := make([]string, len(events))
+	for i, evt := range events {
+		ids[i] = evt.ID
+	}
+	return ids
+}
+
+// expect checks that got are the ids of want, in order.
+func expect(t *testing.T, what string, got []string, want ...*nostr.Event) {
+	t.Helper()
+	ids := make([]string, len(want))
+	for i, evt := range want {
+		ids[i] = evt.ID
+	}
+	if strings.Join(got, ",") != strings.Join(ids, ",") {
+		t.Errorf("%s: got %v, want %v", what, got, ids)
+	}
+}
+
+// expectSet is expect ignoring the order.
+func expectSet(t *testing.T, what string, got []string, want ...*nostr.Event) {
+	t.Helper()
+	got = append([]string(nil), got...)
+	sort.Strings(got)
+	sorted := append([]*nostr.Event(nil), want...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	expect(t, what, got, sorted...)
+}
+
+func testSaveDuplicate(t *testing.T, s relayer.Storage) {
+	evt := newEvent(randomPubKey(t), 1, 100)
+	save(t, s, evt)
+	expect(t, "saved event", query(t, s, nostr.Filter{IDs: []string{evt.ID}}), evt)
+
+	if err := s.SaveEvent(evt); err != storage.ErrDupEvent {
+		t.Errorf("saving the event again returned %v, want storage.ErrDupEvent", err)
+	}
+	expect(t, "saved twice", query(t, s, nostr.Filter{IDs: []string{evt.ID}}), evt)
+}
+
+func testReplaceable(t *testing.T, s relayer.Storage) {
+	pubkey := randomPubKey(t)
+	for _, kind := range []int{nostr.KindSetMetadata, nostr.KindContactList, 10002} {
+		v1 := newEvent(pubkey, kind, 100)
+		v2 := newEvent(pubkey, kind, 200)
+		save(t, s, v1, v2)
+		filter := nostr.Filter{Authors: []string{pubkey}, Kinds: []int{kind}}
+		expect(t, fmt.Sprintf("kind %d newer version", kind), query(t, s, filter), v2)
+
+		if err := s.SaveEvent(newEvent(pubkey, kind, 50)); err == nil {
+			t.Errorf("kind %d: saving an older version succeeded", kind)
+		}
+		expect(t, fmt.Sprintf("kind %d older version", kind), query(t, s, filter), v2)
+
+		// other users have their own
+		save(t, s, newEvent(randomPubKey(t), kind, 300))
+		expect(t, fmt.Sprintf("kind %d of another user", kind), query(t, s, filter), v2)
+	}
+}
+
+func testParameterizedReplaceable(t *testing.T, s relayer.Storage) {
+	pubkey := randomPubKey(t)
+	filter := nostr.Filter{Authors: []string{pubkey}, Kinds: []int{30023}}
+
+	a1 := newEvent(pubkey, 30023, 100, nostr.Tag{"d", "article"})
+	a2 := newEvent(pubkey, 30023, 200, nostr.Tag{"d", "article"})
+	save(t, s, a1, a2)
+	expect(t, "newer version", query(t, s, filter), a2)
+
+	if err := s.SaveEvent(newEvent(pubkey, 30023, 50, nostr.Tag{"d", "article"})); err == nil {
+		t.Errorf("saving an older version succeeded")
+	}
+	expect(t, "older version", query(t, s, filter), a2)
+
+	// other "d" tags are other events
+	b1 := newEvent(pubkey, 30023, 150, nostr.Tag{"d", "list"})
+	save(t, s, b1)
+	expect(t, "another d tag", query(t, s, filter), a2, b1)
+
+	// a missing "d" tag is the same as an empty one
+	c1 := newEvent(pubkey, 30023, 100)
+	c2 := newEvent(pubkey, 30023, 300, nostr.Tag{"d", ""})
+	save(t, s, c1, c2)
+	expect(t, "empty d tag", query(t, s, filter), c2, a2, b1)
+}
+
+func testDelete(t *testing.T, s relayer.Storage) {
+	pubkey := randomPubKey(t)
+	evt := newEvent(pubkey, 1, 100)
+	save(t, s, evt)
+
+	if err := s.DeleteEvent(evt.ID, randomPubKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "deleted by someone else", query(t, s, nostr.Filter{IDs: []string{evt.ID}}), evt)
+
+	if err := s.DeleteEvent(evt.ID, pubkey); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "deleted by the author", query(t, s, nostr.Filter{IDs: []string{evt.ID}}))
+}
+
+func testFilters(t *testing.T, s relayer.Storage) {
+	alice, bob := randomPubKey(t), randomPubKey(t)
+	for bob[:8] == alice[:8] {
+		bob = randomPubKey(t)
+	}
+	e1 := newEvent(alice, 1, 100, nostr.Tag{"e", "x"})
+	e2 := newEvent(alice, 7, 200, nostr.Tag{"e", "x"}, nostr.Tag{"p", "y"})
+	e3 := newEvent(bob, 1, 300, nostr.Tag{"e", "z"}, nostr.Tag{"p", "y"})
+	e4 := newEvent(bob, 1, 400, nostr.Tag{"emoji", "x"})
+	save(t, s, e1, e2, e3, e4)
+
+	both := []string{alice, bob}
+	at := func(ts int64) *time.Time {
+		tm := time.Unix(ts, 0)
+		return &tm
+	}
+
+	for _, tc := range []struct {
+		name   string
+		filter nostr.Filter
+		want   []*nostr.Event
+	}{
+		{"authors, newest first", nostr.Filter{Authors: both}, []*nostr.Event{e4, e3, e2, e1}},
+		{"ids", nostr.Filter{IDs: []string{e1.ID, e3.ID}}, []*nostr.Event{e3, e1}},
+		{"id prefix", nostr.Filter{IDs: []string{e2.ID[:10]}}, []*nostr.Event{e2}},
+		{"author prefix", nostr.Filter{Authors: []string{alice[:8]}}, []*nostr.Event{e2, e1}},
+		{"kinds", nostr.Filter{Authors: both, Kinds: []int{7}}, []*nostr.Event{e2}},
+		{"several kinds", nostr.Filter{Authors: both, Kinds: []int{1, 7}}, []*nostr.Event{e4, e3, e2, e1}},
+		{"no kinds", nostr.Filter{Authors: both, Kinds: []int{}}, nil},
+		{"tag", nostr.Filter{Authors: both, Tags: nostr.TagMap{"e": {"x"}}}, []*nostr.Event{e2, e1}},
+		{"tag values or-ed", nostr.Filter{Authors: both, Tags: nostr.TagMap{"e": {"x", "z"}}}, []*nostr.Event{e3, e2, e1}},
+		{"tags and-ed", nostr.Filter{Authors: both, Tags: nostr.TagMap{"e": {"x"}, "p": {"y"}}}, []*nostr.Event{e2}},
+		{"since is inclusive", nostr.Filter{Authors: both, Since: at(200)}, []*nostr.Event{e4, e3, e2}},
+		{"until is inclusive", nostr.Filter{Authors: both, Until: at(300)}, []*nostr.Event{e3, e2, e1}},
+		{"since and until", nostr.Filter{Authors: both, Since: at(150), Until: at(350)}, []*nostr.Event{e3, e2}},
+		{"everything", nostr.Filter{
+			IDs:     []string{e1.ID, e2.ID, e3.ID},
+			Authors: []string{alice},
+			Kinds:   []int{7},
+			Tags:    nostr.TagMap{"p": {"y"}},
+			Since:   at(100),
+			Until:   at(200),
+		}, []*nostr.Event{e2}},
+	} {
+		expect(t, tc.name, query(t, s, tc.filter), tc.want...)
+	}
+}
+
+func testLimit(t *testing.T, s relayer.Storage) {
+	pubkey := randomPubKey(t)
+	var events []*nostr.Event
+	for i := 1; i <= 5; i++ {
+		events = append(events, newEvent(pubkey, 1, int64(i*100)))
+	}
+	save(t, s, events...)
+
+	filter := nostr.Filter{Authors: []string{pubkey}, Limit: 2}
+	expect(t, "limit", query(t, s, filter), events[4], events[3])
+	filter.Limit = 0
+	expectSet(t, "no limit", query(t, s, filter), events...)
+}
+
+// testCount only runs against the storages implementing relayer.Counter.
+func testCount(t *testing.T, s relayer.Storage) {
+	counter, ok := s.(relayer.Counter)
+	if !ok {
+		t.Skip("not a relayer.Counter")
+	}
+
+	pubkey := randomPubKey(t)
+	for i := 1; i <= 3; i++ {
+		save(t, s, newEvent(pubkey, 1, int64(i*100), nostr.Tag{"e", "x"}))
+	}
+	save(t, s, newEvent(pubkey, 7, 400))
+
+	for _, tc := range []struct {
+		name   string
+		filter nostr.Filter
+		want   int64
+	}{
+		{"author", nostr.Filter{Authors: []string{pubkey}}, 4},
+		{"kinds", nostr.Filter{Authors: []string{pubkey}, Kinds: []int{1}}, 3},
+		{"tags", nostr.Filter{Authors: []string{pubkey}, Tags: nostr.TagMap{"e": {"x"}}}, 3},
+		{"limit is ignored", nostr.Filter{Authors: []string{pubkey}, Limit: 1}, 4},
+		{"no kinds", nostr.Filter{Authors: []string{pubkey}, Kinds: []int{}}, 0},
+	} {
+		count, err := counter.CountEvents(context.Background(), &tc.filter)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if count != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, count, tc.want)
+		}
+	}
+}
+
+func testPeers(t *testing.T, s relayer.Storage) {
+	pubkey := randomPubKey(t)
+	address := "/ip4/127.0.0.1/tcp/10880/p2p/16Uiu2HAmP44YB5WWWdYccDYRzByum6fWDma13csdVUcySzwPMqYx"
+	s.SavePeer(address, pubkey)
+	if got := s.GetPeer(pubkey); got != address {
+		t.Errorf("GetPeer: got %q, want %q", got, address)
+	}
+
+	if lister, ok := s.(relayer.PeerLister); ok {
+		if got := lister.GetPeers(pubkey, 2); len(got) != 1 || got[0] != address {
+			t.Errorf("GetPeers: got %v, want [%s]", got, address)
+		}
+		if got := lister.GetPeers(randomPubKey(t), 2); len(got) != 0 {
+			t.Errorf("GetPeers of an unknown pubkey: got %v", got)
+		}
+	}
+}
+
+// testPrune only runs against the storages implementing relayer.AdvancedSaver,
+// whose AfterSave keeps the newest events of each pubkey and kind.
+func testPrune(t *testing.T, s relayer.Storage) {
+	saver, ok := s.(relayer.AdvancedSaver)
+	if !ok {
+		t.Skip("not a relayer.AdvancedSaver")
+	}
+
+	pubkey := randomPubKey(t)
+	old1, old2 := newEvent(pubkey, 1, 1), newEvent(pubkey, 1, 2)
+	save(t, s, old1, old2)
+	// newer events of another kind don't count against kind 1
+	for i := 0; i < 100; i++ {
+		save(t, s, newEvent(pubkey, 7, int64(1000+i)))
+	}
+
+	saver.AfterSave(old2)
+	expect(t, "pruned kind 1", query(t, s, nostr.Filter{Authors: []string{pubkey}, Kinds: []int{1}}), old2, old1)
+}
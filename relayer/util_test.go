@@ -6,9 +6,12 @@ import (
 	"time"
 
 	"github.com/nbd-wtf/go-nostr"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func startTestRelay(t *testing.T, tr *testRelay) *Server {
+var testTracer = trace.NewNoopTracerProvider().Tracer("test")
+
+func startTestRelay(t *testing.T, tr *testRelay, opts ...Option) *Server {
 	t.Helper()
 	ready := make(chan struct{})
 
@@ -19,7 +22,7 @@ func startTestRelay(t *testing.T, tr *testRelay) *Server {
 			onInitializedFn(s)
 		}
 	}
-	srv := NewServer("127.0.0.1:0", tr)
+	srv := NewServer("127.0.0.1:0", tr, nil, nil, nil, nil, testTracer, opts...)
 	go srv.Start()
 
 	select {
@@ -37,6 +40,7 @@ type testRelay struct {
 	onInitialized func(*Server)
 	onShutdown    func(context.Context)
 	acceptEvent   func(*nostr.Event) bool
+	rateLimits    RateLimits
 }
 
 func (tr *testRelay) Name() string     { return tr.name }
@@ -61,6 +65,8 @@ func (tr *testRelay) OnShutdown(ctx context.Context) {
 	}
 }
 
+func (tr *testRelay) RateLimits() RateLimits { return tr.rateLimits }
+
 func (tr *testRelay) AcceptEvent(e *nostr.Event) bool {
 	if fn := tr.acceptEvent; fn != nil {
 		return fn(e)
@@ -73,6 +79,7 @@ type testStorage struct {
 	queryEvents func(*nostr.Filter) ([]nostr.Event, error)
 	deleteEvent func(id string, pubkey string) error
 	saveEvent   func(*nostr.Event) error
+	peers       map[string]string
 }
 
 func (st *testStorage) Init() error {
@@ -102,3 +109,14 @@ func (st *testStorage) SaveEvent(e *nostr.Event) error {
 	}
 	return nil
 }
+
+func (st *testStorage) SavePeer(address string, pubkey string) {
+	if st.peers == nil {
+		st.peers = make(map[string]string)
+	}
+	st.peers[pubkey] = address
+}
+
+func (st *testStorage) GetPeer(pubkey string) string {
+	return st.peers[pubkey]
+}
@@ -13,6 +13,10 @@ type WebSocket struct {
 	// nip42
 	challenge string
 	authed    string
+
+	// rate limiting, when the server does it
+	ip     string
+	budget *budget
 }
 
 func (ws *WebSocket) WriteJSON(any interface{}) error {
@@ -0,0 +1,272 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// Records waiting to be exported, the ones logged past them are dropped.
+	logQueueSize = 2048
+
+	// Records exported at once.
+	logBatchSize = 512
+
+	// How often the waiting records are exported.
+	logExportInterval = time.Second
+)
+
+// LogHook is a logrus hook shipping the entries as OTLP logs. The entries are
+// exported in batches, in the background, so logging never waits on the
+// collector.
+type LogHook struct {
+	resource *resourcepb.Resource
+	export   func(context.Context, *collogspb.ExportLogsServiceRequest) error
+	// closes the connection of the exporter, if it has one
+	close func() error
+
+	records chan *logspb.LogRecord
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewLogHook creates a hook exporting to cfg.LogExporter, otlp-http or
+// otlp-grpc. The collector is read from OTEL_EXPORTER_OTLP_LOGS_ENDPOINT or
+// OTEL_EXPORTER_OTLP_ENDPOINT, like the other OTLP exporters.
+func NewLogHook(cfg TracerConfig) (*LogHook, error) {
+	h := &LogHook{
+		resource: &resourcepb.Resource{Attributes: keyValues(newResource(cfg).Attributes())},
+		records:  make(chan *logspb.LogRecord, logQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	switch cfg.LogExporter {
+	case "otlp", "otlp-http":
+		endpoint := os.Getenv("OTEL_EXPORTER_OTLP_LOGS_ENDPOINT")
+		if endpoint == "" {
+			endpoint = strings.TrimSuffix(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "/") + "/v1/logs"
+		}
+		if endpoint == "/v1/logs" {
+			endpoint = "http://localhost:4318/v1/logs"
+		}
+		h.export = httpLogExporter(endpoint)
+	case "otlp-grpc":
+		endpoint := os.Getenv("OTEL_EXPORTER_OTLP_LOGS_ENDPOINT")
+		if endpoint == "" {
+			endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		}
+		if endpoint == "" {
+			endpoint = "localhost:4317"
+		}
+		endpoint = strings.TrimPrefix(strings.TrimPrefix(endpoint, "http://"), "https://")
+		conn, err := grpc.Dial(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return nil, fmt.Errorf("cannot create log exporter %s: %w", cfg.LogExporter, err)
+		}
+		h.close = conn.Close
+		client := collogspb.NewLogsServiceClient(conn)
+		h.export = func(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error {
+			_, err := client.Export(ctx, req)
+			return err
+		}
+	default:
+		return nil, fmt.Errorf("cannot create log exporter: unrecognized exporter type %s", cfg.LogExporter)
+	}
+
+	go h.run()
+	return h, nil
+}
+
+func httpLogExporter(endpoint string) func(context.Context, *collogspb.ExportLogsServiceRequest) error {
+	return func(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error {
+		body, err := proto.Marshal(req)
+		if err != nil {
+			return err
+		}
+		r, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		r.Header.Set("Content-Type", "application/x-protobuf")
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("export logs to %s: %s", endpoint, resp.Status)
+		}
+		return nil
+	}
+}
+
+func (h *LogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *LogHook) Fire(entry *logrus.Entry) error {
+	record := &logspb.LogRecord{
+		TimeUnixNano:         uint64(entry.Time.UnixNano()),
+		ObservedTimeUnixNano: uint64(time.Now().UnixNano()),
+		SeverityNumber:       severity(entry.Level),
+		SeverityText:         strings.ToUpper(entry.Level.String()),
+		Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: entry.Message}},
+	}
+
+	sc := trace.SpanContextFromContext(entry.Context)
+	for k, v := range entry.Data {
+		// relayer.Logger logs the span of the context as fields
+		switch k {
+		case "trace_id":
+			if id, err := trace.TraceIDFromHex(fmt.Sprint(v)); err == nil {
+				sc = sc.WithTraceID(id)
+			}
+			continue
+		case "span_id":
+			if id, err := trace.SpanIDFromHex(fmt.Sprint(v)); err == nil {
+				sc = sc.WithSpanID(id)
+			}
+			continue
+		}
+		record.Attributes = append(record.Attributes, &commonpb.KeyValue{
+			Key:   k,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: fmt.Sprint(v)}},
+		})
+	}
+	if sc.HasTraceID() {
+		id := sc.TraceID()
+		record.TraceId = id[:]
+	}
+	if sc.HasSpanID() {
+		id := sc.SpanID()
+		record.SpanId = id[:]
+	}
+
+	select {
+	case h.records <- record:
+	default:
+		// the collector is behind, drop the record rather than block
+	}
+	return nil
+}
+
+// Shutdown exports the waiting records and stops the hook, the entries
+// logged afterwards are dropped.
+func (h *LogHook) Shutdown(ctx context.Context) error {
+	h.once.Do(func() { close(h.stop) })
+	select {
+	case <-h.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if h.close != nil {
+		return h.close()
+	}
+	return nil
+}
+
+func (h *LogHook) run() {
+	defer close(h.done)
+	ticker := time.NewTicker(logExportInterval)
+	defer ticker.Stop()
+
+	var batch []*logspb.LogRecord
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := h.export(ctx, &collogspb.ExportLogsServiceRequest{
+			ResourceLogs: []*logspb.ResourceLogs{{
+				Resource:  h.resource,
+				SchemaUrl: semconv.SchemaURL,
+				ScopeLogs: []*logspb.ScopeLogs{{
+					Scope:      &commonpb.InstrumentationScope{Name: "github.com/sithumonline/demedia-nostr/trace"},
+					LogRecords: batch,
+				}},
+			}},
+		})
+		cancel()
+		if err != nil {
+			// not logged with logrus, it would come back here
+			otel.Handle(err)
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case record := <-h.records:
+			batch = append(batch, record)
+			if len(batch) >= logBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-h.stop:
+			for {
+				select {
+				case record := <-h.records:
+					batch = append(batch, record)
+					if len(batch) >= logBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func severity(level logrus.Level) logspb.SeverityNumber {
+	switch level {
+	case logrus.TraceLevel:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_TRACE
+	case logrus.DebugLevel:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_DEBUG
+	case logrus.InfoLevel:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_INFO
+	case logrus.WarnLevel:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_WARN
+	case logrus.ErrorLevel:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_ERROR
+	default:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_FATAL
+	}
+}
+
+func keyValues(attrs []attribute.KeyValue) []*commonpb.KeyValue {
+	kvs := make([]*commonpb.KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		kvs = append(kvs, &commonpb.KeyValue{
+			Key:   string(a.Key),
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: a.Value.Emit()}},
+		})
+	}
+	return kvs
+}
@@ -0,0 +1,54 @@
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestLogHook(t *testing.T) {
+	requests := make(chan *collogspb.ExportLogsServiceRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req collogspb.ExportLogsServiceRequest
+		if err := proto.Unmarshal(body, &req); err != nil {
+			t.Error(err)
+		}
+		requests <- &req
+	}))
+	defer srv.Close()
+	t.Setenv("OTEL_EXPORTER_OTLP_LOGS_ENDPOINT", srv.URL+"/v1/logs")
+
+	hook, err := NewLogHook(TracerConfig{LogExporter: "otlp-http", ServiceName: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := logrus.New()
+	l.Out = io.Discard
+	l.AddHook(hook)
+	traceID := "0102030405060708090a0b0c0d0e0f10"
+	l.WithFields(logrus.Fields{"trace_id": traceID, "kind": "ping"}).Warn("hub unreachable")
+	if err := hook.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-requests
+	records := req.ResourceLogs[0].ScopeLogs[0].LogRecords
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+	r := records[0]
+	if r.Body.GetStringValue() != "hub unreachable" || r.SeverityText != "WARNING" || hex.EncodeToString(r.TraceId) != traceID {
+		t.Errorf("got record %v", r)
+	}
+	if len(r.Attributes) != 1 || r.Attributes[0].Key != "kind" {
+		t.Errorf("got attributes %v, want only kind", r.Attributes)
+	}
+}
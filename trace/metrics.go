@@ -0,0 +1,66 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// CreateMeters sets the global meter provider to one exporting to
+// cfg.MetricExporter. With prometheus, it returns the handler serving the
+// metrics, to be mounted at /metrics; the other exporters push them and the
+// handler is nil.
+func CreateMeters(cfg TracerConfig) (http.Handler, func(context.Context) error, error) {
+	reader, handler, err := createMetricReader(cfg.MetricExporter)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot create metric exporter %s: %w", cfg.MetricExporter, err)
+	}
+
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(reader),
+		sdkmetric.WithResource(newResource(cfg)),
+	)
+
+	otel.SetMeterProvider(mp)
+
+	return handler, mp.Shutdown, nil
+}
+
+func createMetricReader(exporterType string) (sdkmetric.Reader, http.Handler, error) {
+	var exporter sdkmetric.Exporter
+	var err error
+	switch exporterType {
+	case "prometheus":
+		registry := prometheus.NewRegistry()
+		reader, err := otelprom.New(otelprom.WithRegisterer(registry))
+		if err != nil {
+			return nil, nil, err
+		}
+		return reader, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}), nil
+	case "otlp", "otlp-http":
+		exporter, err = otlpmetrichttp.New(context.Background(),
+			otlpmetrichttp.WithInsecure(),
+		)
+	case "otlp-grpc":
+		exporter, err = otlpmetricgrpc.New(context.Background(),
+			otlpmetricgrpc.WithInsecure(),
+		)
+	case "stdout":
+		exporter, err = stdoutmetric.New()
+	default:
+		return nil, nil, fmt.Errorf("unrecognized exporter type %s", exporterType)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return sdkmetric.NewPeriodicReader(exporter), nil, nil
+}
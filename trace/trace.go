@@ -3,8 +3,9 @@ package trace
 import (
 	"context"
 	"fmt"
-	"log"
+	"net/http"
 
+	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/jaeger"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
@@ -17,38 +18,117 @@ import (
 	"go.opentelemetry.io/otel/trace"
 )
 
+// TracerConfig chooses where the traces, metrics and logs of a service go.
+// An empty MetricExporter or LogExporter turns them off.
 type TracerConfig struct {
-	TraceExporter  string
+	// TraceExporter is jaeger, otlp-http, otlp-grpc or stdout.
+	TraceExporter string
+	// MetricExporter is otlp-http, otlp-grpc, prometheus or stdout.
+	MetricExporter string
+	// LogExporter is otlp-http or otlp-grpc, see NewLogHook.
+	LogExporter string
+
+	// SampleRatio is the share of the traces that are sampled, in [0, 1].
+	SampleRatio float64
+	// ParentBased samples a span when its remote parent was, only the root
+	// spans are subject to SampleRatio.
+	ParentBased bool
+
 	ServiceName    string
 	Environment    string
 	ServiceVersion string
 }
 
-func CreateTracers(cfg TracerConfig) (trace.Tracer, func(context.Context) error) {
+// Telemetry is what Setup created.
+type Telemetry struct {
+	Tracer trace.Tracer
+	// Metrics serves the metrics when MetricExporter is prometheus, it is nil
+	// otherwise.
+	Metrics http.Handler
+	// LogHook ships the logrus entries when LogExporter is set, it is nil
+	// otherwise.
+	LogHook logrus.Hook
+}
+
+// Setup creates the tracers, the meters and the log hook of cfg. The returned
+// function flushes and stops all of them.
+func Setup(cfg TracerConfig) (*Telemetry, func(context.Context) error, error) {
+	var shutdowns []func(context.Context) error
+	shutdown := func(ctx context.Context) error {
+		var first error
+		for i := len(shutdowns) - 1; i >= 0; i-- {
+			if err := shutdowns[i](ctx); err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	}
+
+	t := &Telemetry{}
+	tc, shutdownTracers, err := CreateTracers(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	t.Tracer = tc
+	shutdowns = append(shutdowns, shutdownTracers)
+
+	if cfg.MetricExporter != "" {
+		metrics, shutdownMeters, err := CreateMeters(cfg)
+		if err != nil {
+			shutdown(context.Background())
+			return nil, nil, err
+		}
+		t.Metrics = metrics
+		shutdowns = append(shutdowns, shutdownMeters)
+	}
+
+	if cfg.LogExporter != "" {
+		hook, err := NewLogHook(cfg)
+		if err != nil {
+			shutdown(context.Background())
+			return nil, nil, err
+		}
+		t.LogHook = hook
+		shutdowns = append(shutdowns, hook.Shutdown)
+	}
+
+	return t, shutdown, nil
+}
+
+func newResource(cfg TracerConfig) *resource.Resource {
+	return resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceNameKey.String(cfg.ServiceName),
+		semconv.ServiceVersionKey.String(cfg.ServiceVersion),
+		semconv.DeploymentEnvironmentKey.String(cfg.Environment),
+	)
+}
+
+// CreateTracers sets the global tracer provider to one exporting to
+// cfg.TraceExporter and sampling as cfg says.
+func CreateTracers(cfg TracerConfig) (trace.Tracer, func(context.Context) error, error) {
 	exp, err := createOtelExporter(cfg.TraceExporter)
 	if err != nil {
-		log.Fatalf("cannot create trace exporter %s: %w", cfg.TraceExporter, err)
+		return nil, nil, fmt.Errorf("cannot create trace exporter %s: %w", cfg.TraceExporter, err)
 	}
 
 	tp := sdktrace.NewTracerProvider(
 		sdktrace.WithBatcher(exp),
-		sdktrace.WithResource(resource.NewWithAttributes(
-			semconv.SchemaURL,
-			semconv.ServiceNameKey.String(cfg.ServiceName),
-			semconv.ServiceVersionKey.String(cfg.ServiceVersion),
-			semconv.DeploymentEnvironmentKey.String(cfg.Environment),
-		)),
+		sdktrace.WithSampler(newSampler(cfg)),
+		sdktrace.WithResource(newResource(cfg)),
 	)
 
 	otel.SetTracerProvider(tp)
 
-	return tp.Tracer(cfg.ServiceName), func(ctx context.Context) error {
-		if err := tp.Shutdown(ctx); err != nil {
-			return err
-		}
+	return tp.Tracer(cfg.ServiceName), tp.Shutdown, nil
+}
 
-		return nil
+func newSampler(cfg TracerConfig) sdktrace.Sampler {
+	sampler := sdktrace.TraceIDRatioBased(cfg.SampleRatio)
+	if cfg.ParentBased {
+		return sdktrace.ParentBased(sampler)
 	}
+	return sampler
 }
 
 func createOtelExporter(exporterType string) (sdktrace.SpanExporter, error) {